@@ -1,65 +1,333 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 func TurnResetHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
 	// Check if the user has the correct permissions
 
-	if !HasPermission(dg, i.Member, i.GuildID, "ADMINISTRATOR") {
+	if !HasPermission(dg, i.Member, i.GuildID, "turn-reset") {
 		Respond(dg, i, i18n[lang]["err-not-allowed"])
 		return
 	}
-	// Reset the tournament
-	name := i.ApplicationCommandData().Options[0].StringValue()
-	err := DBResetTournament(backend, name)
+	// Start a new tournament, which becomes the default of this channel
+	name := OptionMap(i)["name"].StringValue()
+	tournamentId, err := DBResetTournament(backend, i.GuildID, name)
 	if err != nil {
 		Respond(dg, i, i18n[lang]["err-reset"])
 		return
 	}
-	Respond(dg, i, fmt.Sprintf(i18n[lang]["ok-reset"], name))
+	err = DBSetChannelTournament(backend, i.ChannelID, tournamentId)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-reset"])
+		return
+	}
+	Respond(dg, i, fmt.Sprintf(i18n[lang]["ok-reset"], name)+"\n"+fmt.Sprintf(i18n[lang]["info-tournament-id"], tournamentId))
+}
+
+func TurnChannelHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
+	// Check if the user has the correct permissions
+	if !HasPermission(dg, i.Member, i.GuildID, "turn-channel") {
+		Respond(dg, i, i18n[lang]["err-not-allowed"])
+		return
+	}
+	tournamentId := SelectTournament(i)
+	if tournamentId == 0 || DBGetTournamentStatus(backend, tournamentId) == "error" {
+		Respond(dg, i, i18n[lang]["err-tournament"])
+		return
+	}
+	err := DBSetChannelTournament(backend, i.ChannelID, tournamentId)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-channel"]+" "+err.Error())
+		return
+	}
+	Respond(dg, i, fmt.Sprintf(i18n[lang]["ok-channel"], DBGetTournamentName(backend, tournamentId), tournamentId))
+}
+
+func TurnGuildHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
+	// Check if the user has the correct permissions
+	if !HasPermission(dg, i.Member, i.GuildID, adminOnly) {
+		Respond(dg, i, i18n[lang]["err-not-allowed"])
+		return
+	}
+	guild := DBGetGuild(backend, i.GuildID)
+	options := OptionMap(i)
+	if l, ok := options["lang"]; ok {
+		guild.Lang = l.StringValue()
+	}
+	if role, ok := options["participant-role"]; ok {
+		guild.ParticipantRoleId = role.RoleValue(dg, i.GuildID).ID
+	}
+	if role, ok := options["organizer-role"]; ok {
+		guild.OrganizerRoleId = role.RoleValue(dg, i.GuildID).ID
+	}
+	err := DBSetGuild(backend, guild)
+	if err == nil && options["command"] != nil {
+		// a command without a role goes back to the default
+		var roleId string
+		if role, ok := options["command-role"]; ok {
+			roleId = role.RoleValue(dg, i.GuildID).ID
+		}
+		err = DBSetCommandRole(backend, i.GuildID, options["command"].StringValue(), roleId)
+	}
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-guild"]+" "+err.Error())
+		return
+	}
+	// answer in the language just chosen
+	lang = GuildLang(i.GuildID)
+	mention := func(id string) string {
+		if id == "" {
+			return i18n[lang]["guild-no-role"]
+		}
+		return "<@&" + id + ">"
+	}
+	message := fmt.Sprintf(i18n[lang]["ok-guild"], lang, mention(GuildParticipantRole(i.GuildID)), mention(GuildOrganizerRole(i.GuildID)))
+	roles := DBGetCommandRoles(backend, i.GuildID)
+	for _, command := range roleCommands {
+		if id, ok := roles[command]; ok {
+			message += "\n/" + command + ": " + mention(id)
+		}
+	}
+	Respond(dg, i, message)
+
+	// the commands are described in the language of the guild
+	turnvater.Refresh(i.GuildID)
+}
+
+// Autocomplete suggests values for the option being typed
+func Autocomplete(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	for _, option := range i.ApplicationCommandData().Options {
+		if !option.Focused {
+			continue
+		}
+		typed := strings.ToLower(fmt.Sprint(option.Value))
+		switch option.Name {
+		case "tournament":
+			TournamentAutocomplete(dg, i, typed)
+		case "p1", "p2", "player":
+			PlayerAutocomplete(dg, i, option.Name, typed)
+		case "group":
+			GroupAutocomplete(dg, i, typed)
+		}
+		return
+	}
+}
+
+// RespondChoices suggests the names which contain the text typed so far, discord shows at most 25 of them
+func RespondChoices(dg *discordgo.Session, i *discordgo.InteractionCreate, names []string, typed string) {
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, name := range names {
+		if !strings.Contains(strings.ToLower(name), typed) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+		if len(choices) == 25 {
+			break
+		}
+	}
+	dg.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+}
+
+// PlayerAutocomplete suggests the participants of the selected tournament. Results are entered for players with an open match,
+// and once one player of the match is chosen, the other is one of its opponents in an open match.
+func PlayerAutocomplete(dg *discordgo.Session, i *discordgo.InteractionCreate, name, typed string) {
+	tournamentId := SelectTournament(i)
+	if i.ApplicationCommandData().Name != "turn-result" {
+		RespondChoices(dg, i, DBGetParticipants(backend, tournamentId, 0), typed)
+		return
+	}
+	opponents, err := DBGetOpponents(backend, tournamentId)
+	if err != nil {
+		fmt.Println("error listing opponents:", err)
+	}
+	// once the other player of the match is chosen, only the opponents in its open matches are left
+	other := map[string]string{"p1": "p2", "p2": "p1"}[name]
+	var players []string
+	if player, ok := OptionMap(i)[other]; ok && player.StringValue() != "" {
+		players = opponents[player.StringValue()]
+	} else {
+		for p := range opponents {
+			players = append(players, p)
+		}
+	}
+	sort.Strings(players)
+	RespondChoices(dg, i, players, typed)
+}
+
+// GroupAutocomplete suggests the open groups of the selected tournament
+func GroupAutocomplete(dg *discordgo.Session, i *discordgo.InteractionCreate, typed string) {
+	var names []string
+	for _, group := range DBGetGroups(backend, SelectTournament(i)) {
+		names = append(names, group.Name)
+	}
+	RespondChoices(dg, i, names, typed)
+}
+
+// TournamentAutocomplete suggests the tournaments whose name or id contains the text typed so far, latest first
+func TournamentAutocomplete(dg *discordgo.Session, i *discordgo.InteractionCreate, typed string) {
+	lang := GuildLang(i.GuildID)
+	tournaments, err := DBGetTournaments(backend, i.GuildID, 100)
+	if err != nil {
+		fmt.Println("error listing tournaments:", err)
+	}
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, t := range tournaments {
+		name := fmt.Sprintf("#%d %s (%s)", t.Id, t.Name, i18n[lang][t.Status])
+		if !strings.Contains(strings.ToLower(name), typed) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: t.Id})
+		if len(choices) == 25 {
+			break
+		}
+	}
+	dg.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
 }
 
 func TurnRegisterHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
+	tournamentId := SelectTournament(i)
 	// check if registration is open
-	status := DBGetTournamentStatus(backend)
+	status := DBGetTournamentStatus(backend, tournamentId)
 	if status != "status-open" {
 		Respond(dg, i, i18n[lang]["err-started"])
 		return
 	}
 
 	// get discord handle, name and IGN parameter
-	ign := i.ApplicationCommandData().Options[0].StringValue()
+	ign := OptionMap(i)["ign"].StringValue()
 	// if ign begins with ! reject
 	if ign[0] == '!' {
 		Respond(dg, i, i18n[lang]["err-register-name"])
 		return
 	}
 
-	err := DBRegisterParticipant(backend, i.Member.User.ID, ign)
+	err := DBRegisterParticipant(backend, tournamentId, i.Member.User.ID, ign)
 	if err != nil {
 		Respond(dg, i, i18n[lang]["err-register"]+" "+err.Error())
 		return
 	}
 
 	// add participant to participant role
-	dg.GuildMemberRoleAdd(i.GuildID, i.Member.User.ID, turnvater.ParticipantRoleId) // ignore errors
+	dg.GuildMemberRoleAdd(i.GuildID, i.Member.User.ID, GuildParticipantRole(i.GuildID)) // ignore errors
 
 	// Register a participant
 	Respond(dg, i, fmt.Sprintf(i18n[lang]["welcome"], i.Member.User.Username, ign))
 }
 
+func TurnSeedHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
+	// Check if the user has the correct permissions
+	if !HasPermission(dg, i.Member, i.GuildID, "turn-seed") {
+		Respond(dg, i, i18n[lang]["err-not-allowed"])
+		return
+	}
+	// seeds are drawn when the tournament starts
+	tournamentId := SelectTournament(i)
+	status := DBGetTournamentStatus(backend, tournamentId)
+	if status != "status-open" {
+		Respond(dg, i, i18n[lang]["err-started"])
+		return
+	}
+	options := OptionMap(i)
+	player := options["player"].StringValue()
+	seed := options["seed"].IntValue()
+	err := DBSetSeed(backend, tournamentId, player, int(seed))
+	if err != nil {
+		Respond(dg, i, err.Error())
+		return
+	}
+	if seed == 0 {
+		Respond(dg, i, fmt.Sprintf(i18n[lang]["ok-unseed"], player))
+		return
+	}
+	Respond(dg, i, fmt.Sprintf(i18n[lang]["ok-seed"], player, seed))
+}
+
+func TurnRatingHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
+	// the history of a single player
+	if player, ok := OptionMap(i)["player"]; ok {
+		r := DBGetRating(backend, i.GuildID, player.UserValue(nil).ID)
+		if r.Player == "" {
+			r.Player = "<@" + r.DiscordId + ">"
+		}
+		message := fmt.Sprintf(i18n[lang]["rating-player"], r.Player, r.Rating, r.Games) + "\n"
+		history, err := DBGetRatingHistory(backend, i.GuildID, r.DiscordId, 10)
+		if err != nil {
+			Respond(dg, i, i18n[lang]["err-rating"]+" "+err.Error())
+			return
+		}
+		for _, c := range history {
+			message += fmt.Sprintf(i18n[lang]["rating-change"], c.Change, c.Opponent, c.Tournament, c.Rating) + "\n"
+		}
+		Respond(dg, i, message)
+		return
+	}
+	// the leaderboard
+	ratings, err := DBGetRatings(backend, i.GuildID)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-rating"]+" "+err.Error())
+		return
+	}
+	message := "*" + i18n[lang]["rating-leaderboard"] + "*\n\n"
+	for k, r := range ratings {
+		if k == 20 {
+			break
+		}
+		message += fmt.Sprintf("%d. ", k+1) + fmt.Sprintf(i18n[lang]["rating-player"], r.Player, r.Rating, r.Games) + "\n"
+	}
+	Respond(dg, i, message)
+}
+
+func TurnHistoryHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
+	tournaments, err := DBGetTournaments(backend, i.GuildID, 20)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-history"]+" "+err.Error())
+		return
+	}
+	message := "*" + i18n[lang]["history"] + "*\n\n"
+	for _, t := range tournaments {
+		result := i18n[lang][t.Status]
+		if t.Winner != "" {
+			result = fmt.Sprintf(i18n[lang]["history-winner"], t.Winner)
+		}
+		message += fmt.Sprintf(i18n[lang]["history-tournament"], t.Id, t.Created, t.Name, t.Participants, result) + "\n"
+	}
+	Respond(dg, i, message)
+}
+
 func TurnStatusHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
 	// Get the status of the tournament
-	status := DBGetTournamentStatus(backend)
-	name := DBGetTournamentName(backend)
-	message := "**" + name + "**\n"
+	tournamentId := SelectTournament(i)
+	status := DBGetTournamentStatus(backend, tournamentId)
+	name := DBGetTournamentName(backend, tournamentId)
+	message := fmt.Sprintf("**%s** (#%d)\n", name, tournamentId)
 	message += i18n[lang]["turn-status"] + ": " + i18n[lang][status] + "\n\n"
-	participants := DBGetParticipants(backend, 0)
+	participants := DBGetParticipants(backend, tournamentId, 0)
 	num := len(participants)
 	message += fmt.Sprintf(i18n[lang]["status-number"], num) + "\n"
 
@@ -72,6 +340,19 @@ func TurnStatusHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
 			groups, rest := CalcGroups(num, i)
 			if groups > 1 {
 				if groups&(groups-1) != 0 {
+					// the knockout can be filled up instead of reducing the number of groups
+					slots := groups
+					if i > 3 {
+						slots *= 2
+					}
+					size := 2
+					for size < slots {
+						size *= 2
+					}
+					if rest < groups {
+						message += fmt.Sprintf(i18n[lang]["info-grouping-fill"], i, groups, size-slots) + ".\n"
+					}
+
 					reduced := 1
 					for reduced < groups {
 						reduced *= 2
@@ -87,16 +368,25 @@ func TurnStatusHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
 		}
 	} else if status == "status-started" {
 		// show grouping info
-		groups := DBGetGroups(backend)
+		format := DBGetFormat(backend, tournamentId)
+		groups := DBGetGroups(backend, tournamentId)
 		for _, g := range groups {
-			message += fmt.Sprintf("**%s:** %s\n", g.Name, strings.Join(g.Participants, ", "))
-			standing, err := DBCalcWinner(backend, g.Id)
-			if err == nil {
-				message += fmt.Sprintf(i18n[lang]["info-current-leaders"], standing.First, standing.Score1, i18n[lang][fmt.Sprintf("win-by-%d", standing.WinBy1)],
-					standing.Second, standing.Score2, i18n[lang][fmt.Sprintf("win-by-%d", standing.WinBy2)]) + "\n"
+			if _, ok := format.(SwissFormat); ok {
+				// all players are in the same round, show the standings instead
+				message += fmt.Sprintf("**%s:**\n", g.Name)
+				order, scores, _ := SwissScores(backend, tournamentId)
+				for _, p := range order {
+					message += ScoreLine(format, p, scores[p], lang) + "\n"
+				}
+			} else {
+				message += fmt.Sprintf("**%s:** %s\n", g.Name, strings.Join(g.Participants, ", "))
+			}
+			standing, err := format.Winner(backend, g.Id)
+			if err == nil && len(standing.Ranking) > 1 {
+				message += fmt.Sprintf(i18n[lang]["info-current-leaders"], LeaderLine(standing, 0, lang), LeaderLine(standing, 1, lang)) + "\n"
 			}
 			//print matches
-			matches := DBGetMatches(backend, g.Id)
+			matches := DBGetMatches(backend, tournamentId, g.Id)
 			for _, m := range matches {
 				if m.Score1 > 0 || m.Score2 > 0 {
 					message += fmt.Sprintf("\t%s vs %s: %d-%d\n", m.Player1, m.Player2, m.Score1, m.Score2)
@@ -104,56 +394,201 @@ func TurnStatusHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
 			}
 		}
 	} else if status == "status-finished" {
-		winner := DBGetTournamentWinner(backend)
+		winner := DBGetTournamentWinner(backend, tournamentId)
 		message += "*" + i18n[lang]["tournament-winner"] + "*: " + winner + "\n"
 	}
 	Respond(dg, i, message)
 }
 
 func TurnStartHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
 	// Check if the user has the correct permissions
-	if !HasPermission(dg, i.Member, i.GuildID, "ADMINISTRATOR") {
+	if !HasPermission(dg, i.Member, i.GuildID, "turn-start") {
 		Respond(dg, i, i18n[lang]["err-not-allowed"])
 		return
 	}
 	// Check if the tournament is not yet started
-	status := DBGetTournamentStatus(backend)
+	tournamentId := SelectTournament(i)
+	status := DBGetTournamentStatus(backend, tournamentId)
 	if status != "status-open" {
 		Respond(dg, i, i18n[lang]["err-start"])
 		return
 	}
 
 	// Start the tournament
-	groupsize := i.ApplicationCommandData().Options[0].IntValue()
-	bestof := i.ApplicationCommandData().Options[1].IntValue()
-	finals := i.ApplicationCommandData().Options[2].IntValue()
-	err := DBStartTournament(backend, groupsize, bestof, finals)
+	settings, err := StartSettings(i)
 	if err != nil {
 		Respond(dg, i, i18n[lang]["err-start"]+" "+err.Error())
 		return
 	}
-	Respond(dg, i, fmt.Sprintf(i18n[lang]["ok-start"], groupsize))
+	err = DBStartTournament(backend, tournamentId, settings)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-start"]+" "+err.Error())
+		return
+	}
+	started := fmt.Sprintf(i18n[lang]["ok-start-format"], i18n[lang]["format-"+settings.Format])
+	if settings.Format == "groups" {
+		started = fmt.Sprintf(i18n[lang]["ok-start"], settings.GroupSize)
+	}
+	Respond(dg, i, started+"\n"+fmt.Sprintf(i18n[lang]["info-draw-seed"], DBGetOption(backend, tournamentId, "draw-seed")))
+}
+
+// StartSettings reads the settings of /turn-start and /turn-preview
+func StartSettings(i *discordgo.InteractionCreate) (Settings, error) {
+	lang := GuildLang(i.GuildID)
+	options := OptionMap(i)
+	settings := Settings{
+		Format: "groups",
+		BestOf: options["bestof"].IntValue(),
+		Finals: options["finals-bestof"].IntValue(),
+	}
+	if groupsize, ok := options["groupsize"]; ok {
+		settings.GroupSize = groupsize.IntValue()
+	}
+	if format, ok := options["format"]; ok {
+		settings.Format = format.StringValue()
+	}
+	if rounds, ok := options["rounds"]; ok {
+		settings.Rounds = rounds.IntValue()
+	}
+	if reset, ok := options["reset"]; ok {
+		settings.Reset = reset.BoolValue()
+	}
+	if fill, ok := options["fill"]; ok {
+		settings.Fill = fill.StringValue()
+	}
+	if advance, ok := options["advance"]; ok {
+		settings.Advance = advance.IntValue()
+	}
+	chain := ""
+	if tiebreakers, ok := options["tiebreakers"]; ok {
+		chain = tiebreakers.StringValue()
+	}
+	if seed, ok := options["seed"]; ok {
+		settings.DrawSeed = seed.IntValue()
+	}
+	if ratingSeeds, ok := options["rating-seeds"]; ok {
+		settings.RatingSeeds = ratingSeeds.BoolValue()
+	}
+	tiebreakers, err := ParseTiebreakers(chain, lang)
+	if err != nil {
+		return settings, err
+	}
+	settings.Tiebreakers = tiebreakers
+	return settings, nil
+}
+
+// TurnPreviewHandler shows the groups and bracket /turn-start would create, without starting the tournament
+func TurnPreviewHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
+	// Check if the user has the correct permissions
+	if !HasPermission(dg, i.Member, i.GuildID, "turn-preview") {
+		Respond(dg, i, i18n[lang]["err-not-allowed"])
+		return
+	}
+	settings, err := StartSettings(i)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-draw"]+" "+err.Error())
+		return
+	}
+	if settings.DrawSeed == 0 {
+		settings.DrawSeed = rand.Int63n(1e9) + 1
+	}
+	plan, err := DBDraw(backend, SelectTournament(i), settings)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-draw"]+" "+err.Error())
+		return
+	}
+	Respond(dg, i, fmt.Sprintf(i18n[lang]["info-draw"], settings.DrawSeed)+"\n\n"+PlanPreview(plan))
+}
 
-	ReRegister()
+// PlanPreview describes the shape of a plan: the size and matches of each group, and who meets in the later rounds
+func PlanPreview(plan Plan) string {
+	lang := plan.Lang
+	matches, wildcards := 0, 0
+	var message string
+	for _, group := range plan.Groups {
+		matches += len(group.Matches)
+		if len(group.Participants) > 0 {
+			message += fmt.Sprintf(i18n[lang]["preview-group"], group.Name, len(group.Participants), len(group.Matches), strings.Join(group.Participants, ", ")) + "\n"
+			continue
+		}
+		for _, m := range group.Matches {
+			message += fmt.Sprintf("**%s:** %s - %s\n", group.Name, plan.SlotName(m.Player1), plan.SlotName(m.Player2))
+			for _, slot := range []Slot{m.Player1, m.Player2} {
+				if slot.Wildcard > 0 {
+					wildcards++
+				}
+			}
+		}
+	}
+	summary := fmt.Sprintf(i18n[lang]["preview-summary"], len(plan.Groups), matches) + "\n"
+	if wildcards > 0 {
+		summary += fmt.Sprintf(i18n[lang]["preview-wildcards"], wildcards, plan.WildcardRank) + "\n"
+	}
+	return summary + message
+}
+
+// TurnDrawHandler repeats the draw of the running tournament for a seed, without changing anything
+func TurnDrawHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
+	// Check if the user has the correct permissions
+	if !HasPermission(dg, i.Member, i.GuildID, "turn-draw") {
+		Respond(dg, i, i18n[lang]["err-not-allowed"])
+		return
+	}
+	tournamentId := SelectTournament(i)
+	status := DBGetTournamentStatus(backend, tournamentId)
+	if status != "status-started" && status != "status-finished" {
+		Respond(dg, i, i18n[lang]["err-not-started"])
+		return
+	}
+	settings := DBGetSettings(backend, tournamentId)
+	if _, ok := formats[settings.Format]; !ok {
+		settings.Format = "groups"
+	}
+	if seed, ok := OptionMap(i)["seed"]; ok {
+		settings.DrawSeed = seed.IntValue()
+	}
+	plan, err := DBDraw(backend, tournamentId, settings)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-draw"]+" "+err.Error())
+		return
+	}
+	Respond(dg, i, fmt.Sprintf(i18n[lang]["info-draw"], settings.DrawSeed)+"\n\n"+PlanSummary(plan))
+}
+
+// PlanSummary lists the participants drawn into each group of a plan
+func PlanSummary(plan Plan) string {
+	var message string
+	for _, group := range plan.Groups {
+		if len(group.Participants) > 0 {
+			message += fmt.Sprintf("**%s:** %s\n", group.Name, strings.Join(group.Participants, ", "))
+		}
+	}
+	return message
 }
 
 func TurnResultHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
-	status := DBGetTournamentStatus(backend)
+	lang := GuildLang(i.GuildID)
+	tournamentId := SelectTournament(i)
+	status := DBGetTournamentStatus(backend, tournamentId)
 	if status != "status-started" {
 		Respond(dg, i, i18n[lang]["err-not-started"])
 		return
 	}
-	p1 := i.ApplicationCommandData().Options[0].StringValue()
-	score1 := i.ApplicationCommandData().Options[1].IntValue()
-	p2 := i.ApplicationCommandData().Options[2].StringValue()
-	score2 := i.ApplicationCommandData().Options[3].IntValue()
+	options := OptionMap(i)
+	p1 := options["p1"].StringValue()
+	score1 := options["score1"].IntValue()
+	p2 := options["p2"].StringValue()
+	score2 := options["score2"].IntValue()
 
 	if p1 == p2 {
 		Respond(dg, i, i18n[lang]["err-no-match"])
 		return
 	}
 
-	group, bestof := DBGetGroupAndBestOf(backend, p1, p2)
+	group, bestof := DBGetGroupAndBestOf(backend, tournamentId, p1, p2)
 	if group.Name == "" {
 		Respond(dg, i, i18n[lang]["err-no-match"])
 		return
@@ -164,48 +599,146 @@ func TurnResultHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
 		Respond(dg, i, fmt.Sprintf(i18n[lang]["err-score-total"], bestof))
 		return
 	}
+	// every knockout match has a winner, whether the result is entered or reported
+	if _, ok := DBGetFormat(backend, tournamentId).(KnockoutFormat); ok && score1 == score2 {
+		Respond(dg, i, i18n[lang]["err-knockout-tie"])
+		return
+	}
 
-	err := DBCreateMatch(backend, p1, p2, score1, score2)
+	if !HasPermission(dg, i.Member, i.GuildID, "turn-result") {
+		// results of players count once their opponent confirms them, admins and the role chosen for the command enter them directly
+		if user := i.Member.User.ID; user != DBGetDiscordId(backend, tournamentId, p1) && user != DBGetDiscordId(backend, tournamentId, p2) {
+			Respond(dg, i, i18n[lang]["err-not-player"])
+			return
+		}
+		report, err := DBReportResult(backend, tournamentId, i.Member.User.ID, p1, p2, score1, score2)
+		if err != nil {
+			Respond(dg, i, i18n[lang]["err-set-score"]+" "+err.Error())
+			return
+		}
+		RespondReport(dg, i, report)
+		return
+	}
+
+	err := DBCreateMatch(backend, tournamentId, p1, p2, score1, score2)
 	if err != nil {
 		Respond(dg, i, i18n[lang]["err-set-score"]+" "+err.Error())
 		return
 	}
+	Respond(dg, i, ResultMessage(group, p1, p2, score1, score2, lang))
+}
 
+// ResultMessage confirms a saved result, and completes the group if this was its last open match
+func ResultMessage(group Group, p1, p2 string, score1, score2 int64, lang string) string {
 	message := i18n[lang]["ok-set-score"] + " " + p1 + " vs " + p2 + ": " + fmt.Sprintf("%d-%d", score1, score2)
 
 	// check if this concludes the group
 	winners, _, err := DBCheckGroupComplete(backend, group.Id)
+	var tiebreak *TiebreakError
+	if errors.As(err, &tiebreak) {
+		return message + "\n\n" + tiebreak.Error()
+	}
 	if err != nil {
-		Respond(dg, i, message+"\n\n"+i18n[lang]["err-group-complete"]+" "+err.Error())
-		return
+		return message + "\n\n" + i18n[lang]["err-group-complete"] + " " + err.Error()
 	}
-	if winners != nil {
+	if len(winners) == 0 && winners != nil {
+		// the group is complete, but nobody advances on their own, e.g. a swiss round
+		message += "\n\n" + fmt.Sprintf(i18n[lang]["ok-close-group"], group.Name) + " " + i18n[lang]["info-games"]
+	} else if winners != nil {
 		// check if the tournament has been won
 		first := winners[0]
 		if first.Group.Id == 0 {
-			DBCloseTournament(backend, first.Player)
-			Respond(dg, i, message+"\n\n"+fmt.Sprintf(i18n[lang]["congratulate"], first.Player))
-			return
+			return message + "\n\n" + fmt.Sprintf(i18n[lang]["congratulate"], first.Player)
 		}
 		// send a new message informing about the promotion
-		message += "\n\n" + fmt.Sprintf(i18n[lang]["ok-group-winner"], first.Player, group.Name, first.Group.Name)
-		if len(winners) > 1 {
-			second := winners[1]
-			message += "\n" + fmt.Sprintf(i18n[lang]["ok-group-second"], second.Player, group.Name, second.Group.Name)
+		message += "\n"
+		for rank, w := range winners {
+			message += "\n" + PromotionMessage(w, group.Name, rank+1, lang)
 		}
 	}
-	Respond(dg, i, message)
+	return message
+}
+
+// RespondReport asks the opponents to confirm or dispute a reported result
+func RespondReport(dg *discordgo.Session, i *discordgo.InteractionCreate, report Report) {
+	lang := GuildLang(i.GuildID)
+	var opponents []string
+	for _, id := range DBReportOpponents(backend, report) {
+		opponents = append(opponents, "<@"+id+">")
+	}
+	dg.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf(i18n[lang]["info-report"], "<@"+report.ReporterId+">", report.Player1, report.Player2, report.Score1, report.Score2, strings.Join(opponents, ", ")),
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.Button{Label: i18n[lang]["btn-confirm"], Style: discordgo.SuccessButton, CustomID: fmt.Sprintf("report-confirm:%d", report.Id)},
+					discordgo.Button{Label: i18n[lang]["btn-dispute"], Style: discordgo.DangerButton, CustomID: fmt.Sprintf("report-dispute:%d", report.Id)},
+				}},
+			},
+		},
+	})
+}
+
+// decidableReport returns the report of a button if the member may decide about it: an opponent of the reporter, or an admin
+func decidableReport(dg *discordgo.Session, i *discordgo.InteractionCreate, reportId int) (Report, bool) {
+	lang := GuildLang(i.GuildID)
+	report, err := DBGetReport(backend, reportId)
+	if err != nil || DBGetTournamentGuild(backend, DBGroupTournament(backend, report.GroupId)) != i.GuildID {
+		Respond(dg, i, i18n[lang]["err-report"])
+		return report, false
+	}
+	if HasPermission(dg, i.Member, i.GuildID, "turn-result") {
+		return report, true
+	}
+	for _, id := range DBReportOpponents(backend, report) {
+		if id == i.Member.User.ID {
+			return report, true
+		}
+	}
+	Respond(dg, i, i18n[lang]["err-not-opponent"])
+	return report, false
+}
+
+func ReportConfirmHandler(dg *discordgo.Session, i *discordgo.InteractionCreate, reportId int) {
+	lang := GuildLang(i.GuildID)
+	if _, ok := decidableReport(dg, i, reportId); !ok {
+		return
+	}
+	report, err := DBConfirmReport(backend, reportId)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-set-score"]+" "+err.Error())
+		return
+	}
+	message := fmt.Sprintf(i18n[lang]["ok-confirm"], "<@"+i.Member.User.ID+">") + "\n" +
+		ResultMessage(Group{Id: report.GroupId, Name: report.GroupName}, report.Player1, report.Player2, int64(report.Score1), int64(report.Score2), lang)
+	UpdateMessage(dg, i, message)
+}
+
+func ReportDisputeHandler(dg *discordgo.Session, i *discordgo.InteractionCreate, reportId int) {
+	lang := GuildLang(i.GuildID)
+	if _, ok := decidableReport(dg, i, reportId); !ok {
+		return
+	}
+	report, err := DBDisputeReport(backend, reportId)
+	if err != nil {
+		Respond(dg, i, i18n[lang]["err-report"]+" "+err.Error())
+		return
+	}
+	UpdateMessage(dg, i, fmt.Sprintf(i18n[lang]["ok-dispute"], "<@"+i.Member.User.ID+">", report.Player1, report.Player2, report.Score1, report.Score2, RefereeMentions(dg, i.GuildID, lang)))
 }
 
 func TurnGamesHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
 	// check if the tournament is running
-	status := DBGetTournamentStatus(backend)
+	tournamentId := SelectTournament(i)
+	status := DBGetTournamentStatus(backend, tournamentId)
 	if status != "status-started" {
 		Respond(dg, i, i18n[lang]["err-not-started"])
 		return
 	}
 	// display all games ordered by group
-	groups, err := DBGetAllGames(backend)
+	groups, err := DBGetAllGames(backend, tournamentId)
 	if err != nil {
 		Respond(dg, i, i18n[lang]["err-get-games"]+" "+err.Error())
 		return
@@ -218,7 +751,11 @@ func TurnGamesHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
 			p1 := match.Player1
 			p2 := match.Player2
 			if p1[0] != '!' && p2[0] != '!' {
-				items += fmt.Sprintf(i18n[lang]["summary-match"], match.Player1, match.Score1, match.Score2, match.Player2) + "\n"
+				items += fmt.Sprintf(i18n[lang]["summary-match"], match.Player1, match.Score1, match.Score2, match.Player2)
+				if match.Tiebreak > 0 {
+					items += i18n[lang]["summary-tiebreak"]
+				}
+				items += "\n"
 			}
 		}
 		if items != "" {
@@ -230,64 +767,116 @@ func TurnGamesHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
 }
 
 func TurnTableHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
 	// check if the tournament is running
-	status := DBGetTournamentStatus(backend)
+	tournamentId := SelectTournament(i)
+	status := DBGetTournamentStatus(backend, tournamentId)
 	if status != "status-started" {
 		Respond(dg, i, i18n[lang]["err-not-started"])
 		return
 	}
 	// display the table of a group
-	group := i.ApplicationCommandData().Options[0].StringValue()
-	g, err := DBGetGroupByName(backend, group)
+	group := OptionMap(i)["group"].StringValue()
+	g, err := DBGetGroupByName(backend, tournamentId, group)
 	if err != nil {
 		Respond(dg, i, i18n[lang]["err-get-games"]+" "+err.Error())
 		return
 	}
 	var message string
-	// get scores and present them as a table
-	scores, err := DBGetScores(backend, g)
+	format := DBGetFormat(backend, tournamentId)
+	message = "*" + group + "*\n\n"
+	// the complete ranking, in the order used for advancement
+	ranks, err := DBGetRanking(backend, g)
 	if err != nil {
 		Respond(dg, i, i18n[lang]["err-get-games"]+" "+err.Error())
 		return
 	}
-	message = "*" + group + "*\n\n"
-	for p, s := range scores {
-		message += fmt.Sprintf(i18n[lang]["summary-score"], p, s.Wins, s.Diff, s.Points) + "\n"
+	for k, r := range ranks {
+		message += TableLine(format, r, k == len(ranks)-1, lang) + "\n"
 	}
 	Respond(dg, i, message)
 }
 
+// LeaderLine names a leader of a group with the score of the criterion that ranks the leader ahead of the next player.
+// Criteria without a score, like the head-to-head or the coin flip, are named without a number.
+func LeaderLine(standing Standing, k int, lang string) string {
+	switch winBy := standing.WinBy[k]; winBy {
+	case WinByWins, WinByPoints, WinByDiff, WinByBuchholz:
+		return fmt.Sprintf("%s (%d %s)", standing.Ranking[k], standing.Scores[k], i18n[lang][fmt.Sprintf("win-by-%d", winBy)])
+	case 0:
+		return standing.Ranking[k] + i18n[lang]["table-tie"]
+	default:
+		return standing.Ranking[k] + fmt.Sprintf(i18n[lang]["table-win-by"], i18n[lang][fmt.Sprintf("win-by-%d", winBy)])
+	}
+}
+
+// PromotionMessage announces that a player advances from a group with the given rank
+func PromotionMessage(advance Advance, group string, rank int, lang string) string {
+	switch rank {
+	case 1:
+		return fmt.Sprintf(i18n[lang]["ok-group-winner"], advance.Player, group, advance.Group.Name)
+	case 2:
+		return fmt.Sprintf(i18n[lang]["ok-group-second"], advance.Player, group, advance.Group.Name)
+	}
+	return fmt.Sprintf(i18n[lang]["ok-group-rank"], advance.Player, rank, group, advance.Group.Name)
+}
+
+// ScoreLine formats one row of a group table
+func ScoreLine(format Format, player string, s Score, lang string) string {
+	if _, ok := format.(SwissFormat); ok {
+		return fmt.Sprintf(i18n[lang]["summary-score-swiss"], player, s.Wins, s.Buchholz, s.Diff, s.Points)
+	}
+	return fmt.Sprintf(i18n[lang]["summary-score"], player, s.Wins, s.Diff, s.Points)
+}
+
+// TableLine prints the position of a player and the criterion which separates the player from the next one
+func TableLine(format Format, r Rank, last bool, lang string) string {
+	line := fmt.Sprintf("%d. ", r.Position) + ScoreLine(format, r.Player, r.Score, lang)
+	if last {
+		return line
+	}
+	if r.WinBy == 0 {
+		return line + i18n[lang]["table-tie"]
+	}
+	return line + fmt.Sprintf(i18n[lang]["table-win-by"], i18n[lang][fmt.Sprintf("win-by-%d", r.WinBy)])
+}
+
 func TurnCloseGroupHandler(dg *discordgo.Session, i *discordgo.InteractionCreate) {
+	lang := GuildLang(i.GuildID)
 	// Check if the user has the correct permissions
-	if !HasPermission(dg, i.Member, i.GuildID, "ADMINISTRATOR") {
+	if !HasPermission(dg, i.Member, i.GuildID, "turn-close-group") {
 		Respond(dg, i, i18n[lang]["err-not-allowed"])
 		return
 	}
 	// Close a group
-	group := i.ApplicationCommandData().Options[0].StringValue()
-	g, err := DBGetGroupByName(backend, group)
+	tournamentId := SelectTournament(i)
+	group := OptionMap(i)["group"].StringValue()
+	g, err := DBGetGroupByName(backend, tournamentId, group)
 	if err != nil {
 		Respond(dg, i, i18n[lang]["err-get-games"]+" "+err.Error())
 		return
 	}
 	winners, _, err := DBDoGroupComplete(backend, g)
+	var tiebreak *TiebreakError
+	if errors.As(err, &tiebreak) {
+		Respond(dg, i, tiebreak.Error())
+		return
+	}
 	if err != nil {
 		Respond(dg, i, i18n[lang]["err-close-group"]+" "+err.Error())
 		return
 	}
 	message := fmt.Sprintf(i18n[lang]["ok-close-group"], group)
-	if winners != nil {
+	if len(winners) > 0 {
 		// check if the tournament has been won
 		first := winners[0]
 		if first.Group.Id == 0 {
-			DBCloseTournament(backend, first.Player)
 			message += "\n\n" + fmt.Sprintf(i18n[lang]["congratulate"], first.Player)
 		} else {
 			// send a new message informing about the promotion
-			message += "\n\n" + fmt.Sprintf(i18n[lang]["ok-group-winner"], first.Player, group, first.Group.Name)
-			if len(winners) > 1 {
-				second := winners[1]
-				message += "\n" + fmt.Sprintf(i18n[lang]["ok-group-second"], second.Player, group, second.Group.Name)
+			message += "\n"
+			for rank, w := range winners {
+				message += "\n" + PromotionMessage(w, group, rank+1, lang)
 			}
 		}
 	}
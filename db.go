@@ -2,16 +2,62 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"math/rand"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 type Match struct {
-	Player1 string
-	Player2 string
-	Score1  int
-	Score2  int
+	Id       int
+	GroupId  int
+	BestOf   int
+	Player1  string
+	Player2  string
+	Score1   int
+	Score2   int
+	Tiebreak int // round of a tiebreak match deciding a tie in the group, 0 for the matches of the regular standings
+}
+
+// selectMatches reads matches with the names of their players, the conditions of the query follow it.
+// A slot without a player waits for the player reaching a rank in another group or for a wildcard, otherwise it is a bye.
+const selectMatches = `SELECT m.id, m.group_id, m.bestof,
+	coalesce(p1.ign, ''), coalesce(m.source1_group, 0), m.source1_rank, m.wildcard1,
+	coalesce(p2.ign, ''), coalesce(m.source2_group, 0), m.source2_rank, m.wildcard2,
+	m.score1, m.score2, m.tiebreak
+	FROM matches m LEFT JOIN participants p1 ON m.player1_id = p1.id LEFT JOIN participants p2 ON m.player2_id = p2.id `
+
+// scanMatch reads a match selected by selectMatches
+func scanMatch(row interface{ Scan(...any) error }) (Match, error) {
+	var m Match
+	var source1, rank1, wildcard1, source2, rank2, wildcard2 int
+	err := row.Scan(&m.Id, &m.GroupId, &m.BestOf, &m.Player1, &source1, &rank1, &wildcard1, &m.Player2, &source2, &rank2, &wildcard2, &m.Score1, &m.Score2, &m.Tiebreak)
+	m.Player1 = slotName(m.Player1, source1, rank1, wildcard1)
+	m.Player2 = slotName(m.Player2, source2, rank2, wildcard2)
+	return m, err
+}
+
+// slotName is the name of the player in a slot of a match, or of what the slot waits for
+func slotName(ign string, source, rank, wildcard int) string {
+	switch {
+	case ign != "":
+		return ign
+	case source > 0:
+		return Placeholder(source, rank)
+	case wildcard > 0:
+		return fmt.Sprintf("!W%d", wildcard)
+	}
+	return Bye
+}
+
+// dbParticipantId is the id of a participant of a tournament, byes and unknown players have none
+func dbParticipantId(db DBTX, tournamentId int, ign string) sql.NullInt64 {
+	var id sql.NullInt64
+	db.QueryRow("SELECT id FROM participants WHERE tournament_id = ? AND ign = ?", tournamentId, ign).Scan(&id)
+	return id
 }
 
 type Group struct {
@@ -21,95 +67,225 @@ type Group struct {
 	Matches      []Match
 }
 
-func DBResetTournament(db *sql.DB, name string) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS options (
-		id INTEGER PRIMARY KEY,
-		key TEXT NOT NULL,
-		value TEXT NOT NULL
-	)`)
-	if err != nil {
-		return err
-	}
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS participants (
-		discord_id TEXT PRIMARY KEY NOT NULL,
-		ign TEXT UNIQUE NOT NULL,
-		group_id INTEGER DEFAULT 0
-	);`)
-	if err != nil {
-		fmt.Println("error creating participants table:", err)
-		return err
+// DBTX is the database or a transaction on it, all DB functions work with both
+type DBTX interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// OpenDB opens a state file, with the foreign keys between tournaments, groups, participants and matches enforced.
+// Readers wait for a running transaction instead of failing.
+func OpenDB(path string) (*sql.DB, error) {
+	// transactions take the write lock when they begin, so that concurrent ones wait for each other instead of failing
+	return sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate")
+}
+
+// DBTransaction runs fn in a transaction, which is rolled back if fn fails. Called within a transaction, fn joins it.
+func DBTransaction(db DBTX, fn func(tx DBTX) error) error {
+	conn, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
 	}
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS groups (id INTEGER PRIMARY KEY, name TEXT NOT NULL, complete INTEGER DEFAULT 0)")
+	tx, err := conn.Begin()
 	if err != nil {
-		fmt.Println("error creating groups table:", err)
 		return err
 	}
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS matches (id INTEGER PRIMARY KEY, group_id INTEGER NOT NULL, bestof INTEGER NOT NULL, player1 TEXT NOT NULL, player2 TEXT NOT NULL, score1 INTEGER DEFAULT 0, score2 INTEGER DEFAULT 0)")
+	err = fn(tx)
 	if err != nil {
-		fmt.Println("error creating matches table:", err)
+		tx.Rollback()
 		return err
 	}
+	return tx.Commit()
+}
 
-	// reset
-	_, err = db.Exec("DELETE FROM participants")
-	if err != nil {
-		fmt.Println("error deleting participants:", err)
-		return err
-	}
-	_, err = db.Exec("DELETE FROM options")
-	if err != nil {
-		fmt.Println("error deleting options:", err)
+// tournamentLocks serializes the operations which check a tournament and then change it, e.g. two results completing the same group
+var tournamentLocks = struct {
+	sync.Mutex
+	byId map[int]*sync.Mutex
+}{byId: make(map[int]*sync.Mutex)}
+
+// LockTournament waits until no other operation changes a tournament, and returns the function to release it
+func LockTournament(tournamentId int) func() {
+	tournamentLocks.Lock()
+	lock, ok := tournamentLocks.byId[tournamentId]
+	if !ok {
+		lock = &sync.Mutex{}
+		tournamentLocks.byId[tournamentId] = lock
+	}
+	tournamentLocks.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// DBResetTournament starts a new tournament in a guild and returns its id. Earlier tournaments are kept in the history, running ones go on.
+func DBResetTournament(db DBTX, guildId, name string) (int, error) {
+	var id int
+	err := DBTransaction(db, func(tx DBTX) error {
+		var err error
+		id, err = dbResetTournament(tx, guildId, name)
 		return err
-	}
-	_, err = db.Exec("DELETE FROM groups")
+	})
+	return id, err
+}
+
+func dbResetTournament(db DBTX, guildId, name string) (int, error) {
+	res, err := db.Exec("INSERT INTO tournaments (guild_id, name) VALUES (?, ?)", guildId, name)
 	if err != nil {
-		fmt.Println("error deleting groups:", err)
-		return err
+		fmt.Println("error creating tournament:", err)
+		return 0, err
 	}
-	_, err = db.Exec("DELETE FROM matches")
+	id, err := res.LastInsertId()
 	if err != nil {
-		fmt.Println("error deleting matches:", err)
-		return err
+		return 0, err
 	}
 
 	// set name
-	_, err = db.Exec("INSERT INTO options (key, value) VALUES ('name', ?)", name)
+	_, err = db.Exec("INSERT INTO options (tournament_id, key, value) VALUES (?, 'name', ?)", id, name)
 	if err != nil {
 		fmt.Println("error setting name:", err)
-		return err
+		return 0, err
 	}
-	_, err = db.Exec("INSERT INTO options (key, value) VALUES ('status', ?)", "status-open")
+	_, err = db.Exec("INSERT INTO options (tournament_id, key, value) VALUES (?, 'status', ?)", id, "status-open")
 	if err != nil {
 		fmt.Println("error setting status:", err)
-		return err
+		return 0, err
 	}
-	return nil
+	return int(id), nil
+}
+
+// DBTournamentId is the id of the tournament started last in a guild, used if neither a command nor its channel selects one
+func DBTournamentId(db DBTX, guildId string) int {
+	var id int
+	db.QueryRow("SELECT coalesce(max(id), 0) FROM tournaments WHERE guild_id = ?", guildId).Scan(&id)
+	return id
+}
+
+// DBGetTournamentGuild is the id of the guild a tournament belongs to
+func DBGetTournamentGuild(db DBTX, tournamentId int) string {
+	var guildId string
+	db.QueryRow("SELECT guild_id FROM tournaments WHERE id = ?", tournamentId).Scan(&guildId)
+	return guildId
+}
+
+// DBAdoptGuild moves the tournaments and ratings of a version without guilds to a guild
+func DBAdoptGuild(db DBTX, guildId string) error {
+	return DBTransaction(db, func(tx DBTX) error {
+		for _, table := range []string{"tournaments", "ratings", "rating_history"} {
+			_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET guild_id = ? WHERE guild_id = ''", table), guildId)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func DBGetOption(db *sql.DB, key string) string {
+// DBActiveTournaments lists the ids of the tournaments of a guild which are not finished yet
+func DBActiveTournaments(db DBTX, guildId string) []int {
+	rows, err := db.Query("SELECT o.tournament_id FROM options o JOIN tournaments t ON o.tournament_id = t.id WHERE t.guild_id = ? AND o.key = 'status' AND o.value != 'status-finished' ORDER BY o.tournament_id", guildId)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// DBSetChannelTournament makes a tournament the default for the commands used in a channel
+func DBSetChannelTournament(db DBTX, channelId string, tournamentId int) error {
+	_, err := db.Exec("INSERT INTO channel_tournaments (channel_id, tournament_id) VALUES (?, ?) ON CONFLICT(channel_id) DO UPDATE SET tournament_id = ?", channelId, tournamentId, tournamentId)
+	return err
+}
+
+// DBGetChannelTournament returns the default tournament of a channel, or 0 if it has none
+func DBGetChannelTournament(db DBTX, channelId string) int {
+	var id int
+	db.QueryRow("SELECT tournament_id FROM channel_tournaments WHERE channel_id = ?", channelId).Scan(&id)
+	return id
+}
+
+// DBGroupTournament is the id of the tournament a group belongs to
+func DBGroupTournament(db DBTX, groupId int) int {
+	var id int
+	db.QueryRow("SELECT tournament_id FROM groups WHERE id = ?", groupId).Scan(&id)
+	return id
+}
+
+func DBGetOption(db DBTX, tournamentId int, key string) string {
 	var value string
-	err := db.QueryRow("SELECT value FROM options WHERE key = ?", key).Scan(&value)
+	err := db.QueryRow("SELECT value FROM options WHERE key = ? AND tournament_id = ?", key, tournamentId).Scan(&value)
 	if err != nil {
 		return "error"
 	}
 	return value
 }
 
-func DBRegisterParticipant(db *sql.DB, discordID, ign string) error {
-	_, err := db.Exec("INSERT OR REPLACE INTO participants (discord_id, ign) VALUES (?, ?)", discordID, ign)
+func DBRegisterParticipant(db DBTX, tournamentId int, discordID, ign string) error {
+	defer LockTournament(tournamentId)()
+	if DBGetTournamentStatus(db, tournamentId) != "status-open" {
+		return errors.New(i18n[DBTournamentLang(db, tournamentId)]["err-started"])
+	}
+	return DBTransaction(db, func(tx DBTX) error {
+		// registering again changes the name, the participant keeps its id
+		_, err := tx.Exec("INSERT INTO participants (tournament_id, discord_id, ign) VALUES (?, ?, ?) ON CONFLICT(tournament_id, discord_id) DO UPDATE SET ign = excluded.ign", tournamentId, discordID, ign)
+		if err != nil {
+			return err
+		}
+		return dbClaimRating(tx, DBGetTournamentGuild(tx, tournamentId), discordID, ign)
+	})
+}
+
+// DBGetDiscordId is the discord id of a participant, empty for byes and unknown players
+func DBGetDiscordId(db DBTX, tournamentId int, ign string) string {
+	var id string
+	db.QueryRow("SELECT discord_id FROM participants WHERE tournament_id = ? AND ign = ?", tournamentId, ign).Scan(&id)
+	return id
+}
+
+// DBSetSeed seeds a participant, players with the same seed form a pot. Seed 0 removes the seed.
+func DBSetSeed(db DBTX, tournamentId int, ign string, seed int) error {
+	res, err := db.Exec("UPDATE participants SET seed = ? WHERE ign = ? AND tournament_id = ?", seed, ign, tournamentId)
 	if err != nil {
 		return err
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf(i18n[DBTournamentLang(db, tournamentId)]["err-seed"], ign)
+	}
 	return nil
 }
 
-func DBGetParticipants(db *sql.DB, groupId int) []string {
+// DBGetSeeds returns the seed of all seeded participants
+func DBGetSeeds(db DBTX, tournamentId int) map[string]int {
+	seeds := make(map[string]int)
+	rows, err := db.Query("SELECT ign, seed FROM participants WHERE seed > 0 AND tournament_id = ?", tournamentId)
+	if err != nil {
+		return seeds
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ign string
+		var seed int
+		if rows.Scan(&ign, &seed) == nil {
+			seeds[ign] = seed
+		}
+	}
+	return seeds
+}
+
+func DBGetParticipants(db DBTX, tournamentId, groupId int) []string {
 	var rows *sql.Rows
 	var err error
 	if groupId > 0 {
 		rows, err = db.Query("SELECT ign FROM participants WHERE group_id = ?", groupId)
 	} else {
-		rows, err = db.Query("SELECT ign FROM participants")
+		rows, err = db.Query("SELECT ign FROM participants WHERE tournament_id = ?", tournamentId)
 	}
 	if err != nil {
 		return nil
@@ -127,126 +303,188 @@ func DBGetParticipants(db *sql.DB, groupId int) []string {
 	return participants
 }
 
-func DBStartTournament(db *sql.DB, groupsize, bestof, finals int64) error {
-	_, err := db.Exec("UPDATE options SET value = ? WHERE key = 'groupsize'", groupsize)
+func DBSetOption(db DBTX, tournamentId int, key, value string) error {
+	res, err := db.Exec("UPDATE options SET value = ? WHERE key = ? AND tournament_id = ?", value, key, tournamentId)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("UPDATE options SET value = ? WHERE key = 'bestof'", bestof)
-	if err != nil {
-		return err
+	if n, _ := res.RowsAffected(); n == 0 {
+		_, err = db.Exec("INSERT INTO options (tournament_id, key, value) VALUES (?, ?, ?)", tournamentId, key, value)
 	}
-	_, err = db.Exec("UPDATE options SET value = ? WHERE key = 'finals-bestof'", finals)
+	return err
+}
+
+func DBGetSettings(db DBTX, tournamentId int) Settings {
+	settings := Settings{Format: DBGetOption(db, tournamentId, "format")}
+	settings.GroupSize, _ = strconv.ParseInt(DBGetOption(db, tournamentId, "groupsize"), 10, 64)
+	settings.BestOf, _ = strconv.ParseInt(DBGetOption(db, tournamentId, "bestof"), 10, 64)
+	settings.Finals, _ = strconv.ParseInt(DBGetOption(db, tournamentId, "finals-bestof"), 10, 64)
+	settings.Rounds, _ = strconv.ParseInt(DBGetOption(db, tournamentId, "rounds"), 10, 64)
+	settings.Reset, _ = strconv.ParseBool(DBGetOption(db, tournamentId, "reset"))
+	settings.Fill = DBGetOption(db, tournamentId, "fill")
+	settings.Advance, _ = strconv.ParseInt(DBGetOption(db, tournamentId, "advance"), 10, 64)
+	settings.DrawSeed, _ = strconv.ParseInt(DBGetOption(db, tournamentId, "draw-seed"), 10, 64)
+	settings.Lang = DBTournamentLang(db, tournamentId)
+	tiebreakers, err := ParseTiebreakers(DBGetOption(db, tournamentId, "tiebreakers"), settings.Lang)
 	if err != nil {
-		return err
+		tiebreakers = DefaultTiebreakers
 	}
-	_, err = db.Exec("UPDATE options SET value = ? WHERE key = 'status'", "status-started")
-	if err != nil {
-		return err
+	settings.Tiebreakers = tiebreakers
+	return settings
+}
+
+// get the format of the running tournament, tournaments started before formats existed are played in groups
+func DBGetFormat(db DBTX, tournamentId int) Format {
+	if format, ok := formats[DBGetOption(db, tournamentId, "format")]; ok {
+		return format
 	}
+	return formats["groups"]
+}
 
-	// group participants randomly
-	participants := DBGetParticipants(db, 0)
-	numGroups := len(participants) / int(groupsize)
-	// reduce number of groups to 2^x
-	if numGroups&(numGroups-1) != 0 {
-		reduced := 1
-		for reduced < numGroups {
-			reduced *= 2
+// DBDraw plans the tournament for the registered participants, without writing anything
+func DBDraw(db DBTX, tournamentId int, settings Settings) (Plan, error) {
+	format, ok := formats[settings.Format]
+	if !ok {
+		return Plan{}, fmt.Errorf("unknown format %s", settings.Format)
+	}
+	if settings.Seeds == nil && settings.RatingSeeds {
+		settings.Seeds = DBRatingSeeds(db, tournamentId)
+	} else if settings.Seeds == nil {
+		settings.Seeds = DBGetSeeds(db, tournamentId)
+	}
+	settings.Lang = DBTournamentLang(db, tournamentId)
+	return format.Plan(DBGetParticipants(db, tournamentId, 0), settings)
+}
+
+// DBStartTournament draws the tournament and stores the settings, a missing draw seed is chosen at random and stored with them.
+// Nothing is stored if the tournament cannot be started.
+func DBStartTournament(db DBTX, tournamentId int, settings Settings) error {
+	defer LockTournament(tournamentId)()
+	return DBTransaction(db, func(tx DBTX) error {
+		if DBGetTournamentStatus(tx, tournamentId) != "status-open" {
+			return errors.New(i18n[DBTournamentLang(tx, tournamentId)]["err-started"])
 		}
-		numGroups = reduced / 2
-	}
+		return dbStartTournament(tx, tournamentId, settings)
+	})
+}
 
-	// shuffle
-	for i := range participants {
-		j := i + rand.Intn(len(participants)-i)
-		participants[i], participants[j] = participants[j], participants[i]
+func dbStartTournament(db DBTX, tournamentId int, settings Settings) error {
+	if settings.DrawSeed == 0 {
+		settings.DrawSeed = rand.Int63n(1e9) + 1
+	}
+	if settings.RatingSeeds && settings.Seeds == nil {
+		settings.Seeds = DBRatingSeeds(db, tournamentId)
+	}
+	plan, err := DBDraw(db, tournamentId, settings)
+	if err != nil {
+		return err
 	}
-	// assign to groups
-	group := 1
-	groupSizes := make(map[int]int)
-	for _, p := range participants {
-		_, err = db.Exec("UPDATE participants SET group_id = ? WHERE ign = ?", group, p)
+	if settings.RatingSeeds {
+		// keep the seeds of the draw, the ratings change during the tournament
+		_, err = db.Exec("UPDATE participants SET seed = 0 WHERE tournament_id = ?", tournamentId)
 		if err != nil {
 			return err
 		}
-		groupSizes[group]++
-		group++
-		if group > numGroups {
-			group = 1
+		for ign, seed := range settings.Seeds {
+			err = DBSetSeed(db, tournamentId, ign, seed)
+			if err != nil {
+				return err
+			}
 		}
 	}
-	// name groups alphabetically
-	for i := 1; i <= numGroups; i++ {
-		_, err = db.Exec("INSERT INTO groups (name) VALUES (?)", fmt.Sprintf("Gruppe %c", 'A'+i-1))
+
+	options := map[string]string{
+		"format":        settings.Format,
+		"groupsize":     strconv.FormatInt(settings.GroupSize, 10),
+		"bestof":        strconv.FormatInt(settings.BestOf, 10),
+		"finals-bestof": strconv.FormatInt(settings.Finals, 10),
+		"rounds":        strconv.FormatInt(settings.Rounds, 10),
+		"reset":         strconv.FormatBool(settings.Reset),
+		"fill":          settings.Fill,
+		"advance":       strconv.FormatInt(settings.Advance, 10),
+		"tiebreakers":   strings.Join(settings.Tiebreakers, ","),
+		"draw-seed":     strconv.FormatInt(settings.DrawSeed, 10),
+		"status":        "status-started",
+	}
+	for key, value := range options {
+		err = DBSetOption(db, tournamentId, key, value)
 		if err != nil {
 			return err
 		}
 	}
-	// populate matches: create one match per pairing in each group
-	for i := 1; i <= numGroups; i++ {
-		participants := DBGetParticipants(db, i)
-		for j := 0; j < len(participants); j++ {
-			for k := j + 1; k < len(participants); k++ {
-				_, err = db.Exec("INSERT INTO matches (group_id, bestof, player1, player2) VALUES (?, ?, ?, ?)", i, bestof, participants[j], participants[k])
-				if err != nil {
-					return err
-				}
+	err = DBWritePlan(db, tournamentId, plan)
+	if err != nil {
+		return err
+	}
+	return DBResolveByes(db, tournamentId)
+}
+
+// create the planned groups and matches, and assign participants to their groups
+func DBWritePlan(db DBTX, tournamentId int, plan Plan) error {
+	ids := make([]int, len(plan.Groups))
+	for i, group := range plan.Groups {
+		res, err := db.Exec("INSERT INTO groups (tournament_id, name) VALUES (?, ?)", tournamentId, group.Name)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		ids[i] = int(id)
+		for _, p := range group.Participants {
+			_, err = db.Exec("UPDATE participants SET group_id = ? WHERE ign = ? AND tournament_id = ?", id, p, tournamentId)
+			if err != nil {
+				return err
 			}
 		}
 	}
-
-	start := 1
-	stop := numGroups
-	for {
-		last := stop
-		// if the declared group size is 4 or more the first two winners advance. If the group size is 3 or less, only the winner advances.
-		if groupsize > 3 && groupSizes[start] > 2 {
-			for i := start; i <= stop; i += 2 {
-				last += 2
-				_, err = db.Exec("INSERT INTO groups (name) VALUES (?)", fmt.Sprintf(i18n[lang]["winner-second"], 'A'+last-2, 'A'+i-1, 'A'+i))
-				if err != nil {
-					return err
-				}
-				_, err = db.Exec("INSERT INTO matches (group_id, bestof, player1, player2) VALUES (?, ?, ?, ?)", last-1, finals, fmt.Sprintf("!G%d", i), fmt.Sprintf("!G%d.2", i+1))
-				if err != nil {
-					return err
-				}
-				_, err = db.Exec("INSERT INTO groups (name) VALUES (?)", fmt.Sprintf(i18n[lang]["winner-second"], 'A'+last-1, 'A'+i, 'A'+i-1))
-				if err != nil {
-					return err
-				}
-				_, err = db.Exec("INSERT INTO matches (group_id, bestof, player1, player2) VALUES (?, ?, ?, ?)", last, finals, fmt.Sprintf("!G%d.2", i), fmt.Sprintf("!G%d", i+1))
-				if err != nil {
-					return err
-				}
+	if len(plan.Wildcards) > 0 {
+		var wildcards []string
+		for _, i := range plan.Wildcards {
+			wildcards = append(wildcards, strconv.Itoa(ids[i-1]))
+		}
+		err := DBSetOption(db, tournamentId, "wildcard-groups", strings.Join(wildcards, ","))
+		if err != nil {
+			return err
+		}
+		err = DBSetOption(db, tournamentId, "wildcard-rank", strconv.Itoa(plan.WildcardRank))
+		if err != nil {
+			return err
+		}
+	}
+	for i, group := range plan.Groups {
+		for _, m := range group.Matches {
+			// a bye is won without playing, a slot waiting for its player gets the walkover once the player is known, see DBResolveByes
+			var walkover int64
+			if m.Player2.Player == Bye && m.Player1.Group == 0 && m.Player1.Wildcard == 0 {
+				walkover = m.BestOf/2 + 1
 			}
-		} else {
-			for i := start; i <= stop; i += 2 {
-				last += 1
-				_, err = db.Exec("INSERT INTO groups (name) VALUES (?)", fmt.Sprintf(i18n[lang]["winner-groups"], 'A'+last-1, 'A'+i-1, 'A'+i))
-				if err != nil {
-					return err
-				}
-				_, err = db.Exec("INSERT INTO matches (group_id, bestof, player1, player2) VALUES (?, ?, ?, ?)", last, finals, fmt.Sprintf("!G%d", i), fmt.Sprintf("!G%d", i+1))
-				if err != nil {
-					return err
-				}
+			args := []any{ids[i], m.BestOf}
+			args = append(args, dbSlotValues(db, tournamentId, m.Player1, ids)...)
+			args = append(args, dbSlotValues(db, tournamentId, m.Player2, ids)...)
+			_, err := db.Exec(`INSERT INTO matches (group_id, bestof, player1_id, source1_group, source1_rank, wildcard1, player2_id, source2_group, source2_rank, wildcard2, score1)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, append(args, walkover)...)
+			if err != nil {
+				return err
 			}
 		}
-		start = stop + 1
-		if last == stop+1 {
-			// if we only added one group, that's the finals
-			break
-		}
-		stop = last
 	}
 	return nil
 }
 
+// dbSlotValues are the player_id, source_group, source_rank and wildcard of a planned slot, ids maps the plan index to the group id
+func dbSlotValues(db DBTX, tournamentId int, s Slot, ids []int) []any {
+	var source sql.NullInt64
+	if s.Group > 0 {
+		source = sql.NullInt64{Int64: int64(ids[s.Group-1]), Valid: true}
+	}
+	return []any{dbParticipantId(db, tournamentId, s.Player), source, s.Rank, s.Wildcard}
+}
+
 // get all groups and their participants
-func DBGetGroups(db *sql.DB) []Group {
-	rows, err := db.Query("SELECT g.id, g.name, p.ign FROM groups g LEFT JOIN participants p ON g.id = p.group_id WHERE p.ign IS NOT NULL AND g.complete = 0 ORDER BY g.id, p.ign")
+func DBGetGroups(db DBTX, tournamentId int) []Group {
+	rows, err := db.Query("SELECT g.id, g.name, p.ign FROM groups g LEFT JOIN participants p ON g.id = p.group_id WHERE p.ign IS NOT NULL AND g.complete = 0 AND g.tournament_id = ? ORDER BY g.id, p.ign", tournamentId)
 	if err != nil {
 		return nil
 	}
@@ -275,90 +513,156 @@ func DBGetGroups(db *sql.DB) []Group {
 	return result
 }
 
-func DBGetAllGames(db *sql.DB) ([]Group, error) {
-	rows, err := db.Query("SELECT g.id, g.name, m.player1, m.player2, m.score1, m.score2 FROM matches m LEFT JOIN groups g ON m.group_id = g.id WHERE g.complete = 0 ORDER BY g.id, m.player1, m.player2")
+// DBGetOpponents lists the opponents of each player in the matches without result in the open groups of a tournament.
+// Slots still waiting for a player and byes are not opponents.
+func DBGetOpponents(db DBTX, tournamentId int) (map[string][]string, error) {
+	groups, err := DBGetAllGames(db, tournamentId)
+	if err != nil {
+		return nil, err
+	}
+	opponents := make(map[string][]string)
+	for _, group := range groups {
+		for _, m := range group.Matches {
+			if m.Player1[0] == '!' || m.Player2[0] == '!' || m.Score1 > 0 || m.Score2 > 0 {
+				continue
+			}
+			opponents[m.Player1] = append(opponents[m.Player1], m.Player2)
+			opponents[m.Player2] = append(opponents[m.Player2], m.Player1)
+		}
+	}
+	return opponents, nil
+}
+
+func DBGetAllGames(db DBTX, tournamentId int) ([]Group, error) {
+	rows, err := db.Query("SELECT id, name FROM groups WHERE complete = 0 AND tournament_id = ? ORDER BY id", tournamentId)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 	var groups []Group
 	for rows.Next() {
 		var g Group
-		var m Match
-		err = rows.Scan(&g.Id, &g.Name, &m.Player1, &m.Player2, &m.Score1, &m.Score2)
+		err = rows.Scan(&g.Id, &g.Name)
 		if err != nil {
+			rows.Close()
 			return nil, err
 		}
-		// if the last id is different, add the group to the list
-		if len(groups) == 0 || groups[len(groups)-1].Id != g.Id {
-			groups = append(groups, g)
+		groups = append(groups, g)
+	}
+	rows.Close()
+	for k := range groups {
+		groups[k].Matches, err = dbQueryMatches(db, "WHERE m.group_id = ? ORDER BY m.tiebreak, p1.ign, p2.ign", groups[k].Id)
+		if err != nil {
+			return nil, err
 		}
-		groups[len(groups)-1].Matches = append(groups[len(groups)-1].Matches, m)
 	}
 	return groups, nil
 }
 
-func DBGetGroupAndBestOf(db *sql.DB, p1, p2 string) (Group, int) {
-	var groupId, bestof int
-	var groupName string
-	err := db.QueryRow("SELECT g.id, g.name, m.bestof FROM matches m LEFT JOIN groups g ON m.group_id = g.id WHERE g.complete = 0 AND ((m.player1 = ? AND m.player2 = ?) OR (m.player1 = ? AND m.player2 = ?))", p1, p2, p2, p1).Scan(&groupId, &groupName, &bestof)
+func DBGetGroupAndBestOf(db DBTX, tournamentId int, p1, p2 string) (Group, int) {
+	m, err := DBFindMatch(db, tournamentId, p1, p2)
 	if err != nil {
 		return Group{}, 0
 	}
-	return Group{Id: groupId, Name: groupName}, bestof
+	group := Group{Id: m.GroupId}
+	db.QueryRow("SELECT name FROM groups WHERE id = ?", m.GroupId).Scan(&group.Name)
+	return group, m.BestOf
 }
 
-func DBCreateMatch(db *sql.DB, p1, p2 string, score1, score2 int64) error {
-	_, err := db.Exec("UPDATE matches SET score1 = ?, score2 = ? WHERE (player1 = ? AND player2 = ?)", score1, score2, p1, p2, p2, p1)
-	if err != nil {
-		return err
+// DBFindMatch finds the match between two players in an open group, earlier encounters keep their result.
+// Once a tiebreak match between the players is scheduled, it is the latest tiebreak match.
+func DBFindMatch(db DBTX, tournamentId int, p1, p2 string) (Match, error) {
+	return scanMatch(db.QueryRow(selectMatches+`JOIN groups g ON m.group_id = g.id
+		WHERE g.complete = 0 AND g.tournament_id = ? AND ((p1.ign = ? AND p2.ign = ?) OR (p1.ign = ? AND p2.ign = ?))
+		ORDER BY m.tiebreak DESC, m.id DESC`, tournamentId, p1, p2, p2, p1))
+}
+
+// set the score of the match between two players in an open group, see DBFindMatch
+func DBCreateMatch(db DBTX, tournamentId int, p1, p2 string, score1, score2 int64) error {
+	defer LockTournament(tournamentId)()
+	return DBTransaction(db, func(tx DBTX) error {
+		m, err := DBFindMatch(tx, tournamentId, p1, p2)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if m.Player1 != p1 {
+			score1, score2 = score2, score1
+		}
+		return DBSetScore(tx, m.Id, score1, score2)
+	})
+}
+
+// DBSetScore sets the score of a match, and updates the ratings of both players with the result.
+// Reports of the match waiting for confirmation are replaced by the score.
+func DBSetScore(db DBTX, matchId int, score1, score2 int64) error {
+	return DBTransaction(db, func(tx DBTX) error {
+		_, err := tx.Exec("UPDATE matches SET score1 = ?, score2 = ? WHERE id = ?", score1, score2, matchId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE reports SET status = 'replaced' WHERE match_id = ? AND status = 'pending'", matchId)
+		if err != nil {
+			return err
+		}
+		return DBRateMatch(tx, matchId)
+	})
+}
+
+// get the matches of a group, or of all groups if groupId is 0
+func DBGetMatches(db DBTX, tournamentId, groupId int) []Match {
+	var matches []Match
+	var err error
+	if groupId > 0 {
+		matches, err = dbQueryMatches(db, "WHERE m.group_id = ?", groupId)
+	} else {
+		matches, err = dbQueryMatches(db, "WHERE m.group_id IN (SELECT id FROM groups WHERE tournament_id = ?)", tournamentId)
 	}
-	_, err = db.Exec("UPDATE matches SET score1 = ?, score2 = ? WHERE (player1 = ? AND player2 = ?)", score2, score1, p2, p1)
 	if err != nil {
-		return err
+		return nil
 	}
-	return nil
+	return matches
 }
 
-func DBGetMatches(db *sql.DB, groupId int) []Match {
-	rows, err := db.Query("SELECT player1, player2, score1, score2 FROM matches WHERE group_id = ?", groupId)
+// dbQueryMatches reads the matches selected by the conditions
+func dbQueryMatches(db DBTX, conditions string, args ...any) ([]Match, error) {
+	rows, err := db.Query(selectMatches+conditions, args...)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	defer rows.Close()
 	var matches []Match
 	for rows.Next() {
-		var m Match
-		err = rows.Scan(&m.Player1, &m.Player2, &m.Score1, &m.Score2)
+		m, err := scanMatch(rows)
 		if err != nil {
-			return nil
+			return nil, err
 		}
 		matches = append(matches, m)
 	}
-	return matches
+	return matches, nil
 }
 
 type Score struct {
-	Wins   int
-	Points int
-	Diff   int
+	Wins     int
+	Points   int
+	Diff     int
+	Buchholz int
 }
 
-func DBGetScores(db *sql.DB, groupId int) (map[string]Score, error) {
-	scores := make(map[string]Score)
-
-	rows, err := db.Query("SELECT player1, score1, player2, score2 FROM matches WHERE group_id = ?", groupId)
+func DBGetScores(db DBTX, groupId int) (map[string]Score, error) {
+	matches, err := dbQueryMatches(db, "WHERE m.group_id = ? AND m.tiebreak = 0", groupId)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var p1, p2 string
-		var s1, s2 int
-		err := rows.Scan(&p1, &s1, &p2, &s2)
-		if err != nil {
-			return nil, err
-		}
+	return CalcScores(matches), nil
+}
+
+// CalcScores sums up wins, points and score difference of the players in the given matches
+func CalcScores(matches []Match) map[string]Score {
+	scores := make(map[string]Score)
+	for _, m := range matches {
+		p1, p2, s1, s2 := m.Player1, m.Player2, m.Score1, m.Score2
 		if _, ok := scores[p1]; !ok {
 			scores[p1] = Score{}
 		}
@@ -377,7 +681,13 @@ func DBGetScores(db *sql.DB, groupId int) (map[string]Score, error) {
 
 		}
 	}
-	return scores, nil
+	// byes and unresolved placeholders are not players
+	for p := range scores {
+		if p[0] == '!' {
+			delete(scores, p)
+		}
+	}
+	return scores
 }
 
 type Advance struct {
@@ -390,144 +700,169 @@ type WinBy int
 const WinByWins WinBy = 1
 const WinByPoints WinBy = 2
 const WinByDiff WinBy = 3
+const WinByBuchholz WinBy = 4
+const WinByHeadToHead WinBy = 5
+const WinByMiniTable WinBy = 6
+const WinByDraw WinBy = 7
+const WinByTiebreak WinBy = 8
 
+// Standing is the ranking of a group, best first
 type Standing struct {
-	First  string
-	Second string
-	WinBy1 WinBy
-	WinBy2 WinBy
-	Score1 int
-	Score2 int
+	Ranking []string
+	WinBy   []WinBy // the criterion that ranks each player ahead of the next one, 0 for a tie
+	Scores  []int   // the value of that criterion
 }
 
-func DBCalcWinner(db *sql.DB, groupId int) (Standing, error) {
-	// calculate winner
-	result := Standing{}
+// Rank is one line of a group table
+type Rank struct {
+	Position int // players tied on all tiebreakers share a position
+	Player   string
+	Score
+	WinBy WinBy // the criterion that ranks the player ahead of the next one, 0 for a tie
+	Value int   // the value of that criterion
+}
 
-	scores, err := DBGetScores(db, groupId)
-	if err != nil {
-		return result, err
-	}
-	// find winner
-	var maxScore int
-	var maxWins int
-	tie := false
-
-	// TODO: generalize this
-	for p, s := range scores {
-		if s.Wins > maxWins {
-			tie = false
-			maxWins = s.Wins
-			result.First = p
-		} else if s.Wins == maxWins {
-			tie = true
-		}
-	}
-	result.WinBy1 = WinByWins
-	result.Score1 = maxWins
-
-	maxScore = -1000
-
-	// if there is no winner, identify by diff
-	if tie {
-		for p, s := range scores {
-			if s.Wins == maxWins && s.Diff > maxScore {
-				tie = false
-				maxScore = s.Diff
-				result.First = p
-			} else if s.Wins == maxWins && s.Diff == maxScore {
-				tie = true
-			}
+// Ranks combines a standing with the scores of its players
+func Ranks(standing Standing, scores map[string]Score) []Rank {
+	var ranks []Rank
+	for k, p := range standing.Ranking {
+		position := k + 1
+		if k > 0 && standing.WinBy[k-1] == 0 {
+			position = ranks[k-1].Position
 		}
-		result.WinBy1 = WinByDiff
-		result.Score1 = maxScore
+		ranks = append(ranks, Rank{Position: position, Player: p, Score: scores[p], WinBy: standing.WinBy[k], Value: standing.Scores[k]})
 	}
+	return ranks
+}
 
-	// if there is no winner, identify by points
-	/* disabled by popular vote
-	if tie {
-		for p, s := range scores {
-			if s.Wins == maxWins && s.Points > maxScore {
-				tie = false
-				maxScore = s.Points
-				result.First = p
-			} else if s.Wins == maxWins && s.Points == maxScore {
-				tie = true
-			}
-		}
-		result.WinBy1 = WinByPoints
-		result.Score1 = maxScore
-	} */
-
-	if tie {
-		return result, fmt.Errorf(i18n[lang]["err-group-complete"] + i18n[lang]["perfect-draw-first"])
+// DBRankGroup ranks all players of a group by the tiebreakers of the tournament, best first
+func DBRankGroup(db DBTX, groupId int) (Standing, map[string]Score, error) {
+	tournamentId := DBGroupTournament(db, groupId)
+	scores, err := DBGetScores(db, groupId)
+	if err != nil {
+		return Standing{}, nil, err
 	}
+	var players []string
+	for p := range scores {
+		players = append(players, p)
+	}
+	// ties left by the tiebreakers are decided by tiebreak matches
+	chain := append(append([]string{}, DBGetSettings(db, tournamentId).Tiebreakers...), TiebreakMatches)
+	return RankPlayers(players, chain, scores, DBGetMatches(db, tournamentId, groupId), groupId), scores, nil
+}
 
-	// identify second place, but only if the group size is > 2
-	if len(scores) < 3 {
-		return result, nil
+// DBGetRanking returns the complete table of a group. In swiss tournaments, the table spans all rounds.
+func DBGetRanking(db DBTX, groupId int) ([]Rank, error) {
+	tournamentId := DBGroupTournament(db, groupId)
+	if _, ok := DBGetFormat(db, tournamentId).(SwissFormat); ok {
+		order, scores, _ := SwissScores(db, tournamentId)
+		return Ranks(SwissStanding(order, scores), scores), nil
+	}
+	standing, scores, err := DBRankGroup(db, groupId)
+	if err != nil {
+		return nil, err
 	}
+	return Ranks(standing, scores), nil
+}
 
-	maxWins = 0
-	maxScore = 0
-	tie = false
+// DrawError reports players tied for one of the places that decide the group
+type DrawError struct {
+	Place   int
+	Players []string
+	// Lang is the language of the message
+	Lang string
+}
 
-	for p, s := range scores {
-		if p != result.First {
-			if s.Wins > maxWins {
-				maxWins = s.Wins
-				result.Second = p
-				tie = false
-			} else if s.Wins == maxWins {
-				tie = true
-			}
-		}
-	}
-	result.WinBy2 = WinByWins
-	result.Score2 = maxWins
+func (e *DrawError) Error() string {
+	return fmt.Sprintf(i18n[e.Lang]["err-group-complete"]+i18n[e.Lang]["perfect-draw"], e.Place)
+}
+
+// TiebreakError reports the tiebreak matches which have to be played before the group is complete
+type TiebreakError struct {
+	Place   int
+	Matches []Match
+	// Lang is the language of the message
+	Lang string
+}
 
-	maxScore = -1000
+func (e *TiebreakError) Error() string {
+	var matches []string
+	for _, m := range e.Matches {
+		matches = append(matches, m.Player1+" vs "+m.Player2)
+	}
+	return fmt.Sprintf(i18n[e.Lang]["info-tiebreak"], e.Place, strings.Join(matches, ", "))
+}
 
-	if tie {
-		for p, s := range scores {
-			if p != result.First {
-				if s.Wins == maxWins && s.Diff > maxScore {
-					maxScore = s.Diff
-					result.Second = p
-					tie = false
-				} else if s.Wins == maxWins && s.Diff == maxScore {
-					tie = true
-				}
+// DBCalcWinner ranks the players of a group by the tiebreakers of the tournament.
+// A tie that decides about one of the first places is a DrawError.
+func DBCalcWinner(db DBTX, groupId int, places int) (Standing, error) {
+	result, _, err := DBRankGroup(db, groupId)
+	if err != nil {
+		return Standing{}, err
+	}
+	for i := 0; i+1 < len(result.Ranking) && i < places; i++ {
+		if result.WinBy[i] == 0 {
+			// all players tied with the one at this place
+			start, end := i, i+1
+			for start > 0 && result.WinBy[start-1] == 0 {
+				start--
+			}
+			for end < len(result.Ranking)-1 && result.WinBy[end] == 0 {
+				end++
 			}
+			return result, &DrawError{Place: start + 1, Players: result.Ranking[start : end+1], Lang: DBTournamentLang(db, DBGroupTournament(db, groupId))}
 		}
-		result.WinBy2 = WinByDiff
-		result.Score2 = maxScore
 	}
+	return result, nil
+}
 
-	/* disabled by popular vote
-	if tie {
-		for p, s := range scores {
-			if p != result.First {
-				if s.Wins == maxWins && s.Points > maxScore {
-					maxScore = s.Points
-					result.Second = p
-					tie = false
-				} else if s.Wins == maxWins && s.Points == maxScore {
-					tie = true
+// DBScheduleTiebreak adds tiebreak matches between the tied players of a group, unless they are already scheduled.
+// Every pair of tied players meets once per round of tiebreak matches, until the tie is broken.
+func DBScheduleTiebreak(db DBTX, groupId int, draw *DrawError) error {
+	tied := make(map[string]bool)
+	for _, p := range draw.Players {
+		tied[p] = true
+	}
+	var open []Match
+	round := 0
+	for _, m := range DBGetMatches(db, 0, groupId) {
+		if m.Tiebreak == 0 || !tied[m.Player1] || !tied[m.Player2] {
+			continue
+		}
+		if m.Score1 == 0 && m.Score2 == 0 {
+			open = append(open, m)
+		}
+	}
+	err := db.QueryRow("SELECT coalesce(max(tiebreak), 0) FROM matches WHERE group_id = ?", groupId).Scan(&round)
+	if err != nil {
+		return err
+	}
+	if len(open) == 0 {
+		var bestof int64
+		err = db.QueryRow("SELECT max(bestof) FROM matches WHERE group_id = ?", groupId).Scan(&bestof)
+		if err != nil {
+			return err
+		}
+		tournamentId := DBGroupTournament(db, groupId)
+		for j := 0; j < len(draw.Players); j++ {
+			for k := j + 1; k < len(draw.Players); k++ {
+				m := Match{Player1: draw.Players[j], Player2: draw.Players[k], Tiebreak: round + 1}
+				_, err = db.Exec("INSERT INTO matches (group_id, bestof, player1_id, player2_id, tiebreak) VALUES (?, ?, ?, ?, ?)",
+					groupId, bestof, dbParticipantId(db, tournamentId, m.Player1), dbParticipantId(db, tournamentId, m.Player2), m.Tiebreak)
+				if err != nil {
+					return err
 				}
+				open = append(open, m)
 			}
 		}
-		result.WinBy2 = WinByPoints
-		result.Score2 = maxScore
-	}
-	*/
-	if tie {
-		return result, fmt.Errorf(i18n[lang]["err-group-complete"] + i18n[lang]["perfect-draw-second"])
 	}
-	return result, nil
+	return &TiebreakError{Place: draw.Place, Matches: open, Lang: draw.Lang}
 }
 
-func DBCheckGroupComplete(db *sql.DB, groupId int) ([]Advance, *Standing, error) {
+// DBCheckGroupComplete completes a group once all its matches have a result, see DBDoGroupComplete.
+// The group is completed once, even if several results complete it at the same time.
+func DBCheckGroupComplete(db DBTX, groupId int) ([]Advance, *Standing, error) {
+	defer LockTournament(DBGroupTournament(db, groupId))()
 	// check if the group is closed
 	var complete int
 	err := db.QueryRow("SELECT complete FROM groups WHERE id = ?", groupId).Scan(&complete)
@@ -546,128 +881,284 @@ func DBCheckGroupComplete(db *sql.DB, groupId int) ([]Advance, *Standing, error)
 	if openMatches > 0 {
 		return nil, nil, nil
 	}
-	return DBDoGroupComplete(db, groupId)
+	return dbCompleteGroup(db, groupId)
 }
 
-func DBDoGroupComplete(db *sql.DB, groupId int) ([]Advance, *Standing, error) {
-	// group is complete or being closed, identify the successor(s)
-	var nextGroupA Group
-	var nextGroupB Group
-	rows, err := db.Query("SELECT m.group_id, g.name FROM matches m LEFT JOIN groups g ON m.group_id = g.id WHERE player1 = ? OR player2 = ?", fmt.Sprintf("!G%d", groupId), fmt.Sprintf("!G%d", groupId))
-	if err != nil {
-		return nil, nil, err
-	}
-	defer rows.Close()
-	// if no rows returned
-	if !rows.Next() {
-		nextGroupA.Id = 0
-		nextGroupA.Name = i18n[lang]["tournament-winner"]
-	} else {
-		err = rows.Scan(&nextGroupA.Id, &nextGroupA.Name)
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-	rows.Close()
-
-	// read participant count
-	var participantCount int
-	err = db.QueryRow("SELECT count(*) FROM participants WHERE group_id = ?", groupId).Scan(&participantCount)
-	if err != nil {
-		return nil, nil, err
-	}
+// DBDoGroupComplete completes a group in one transaction: the players advance, byes are resolved and a won tournament is closed.
+// If the group cannot be completed nothing changes, except for tiebreak matches scheduled instead.
+func DBDoGroupComplete(db DBTX, groupId int) ([]Advance, *Standing, error) {
+	defer LockTournament(DBGroupTournament(db, groupId))()
+	return dbCompleteGroup(db, groupId)
+}
 
-	if participantCount > 3 {
-		rows, err = db.Query("SELECT m.group_id, g.name FROM matches m LEFT JOIN groups g ON m.group_id = g.id WHERE player1 = ? OR player2 = ?", fmt.Sprintf("!G%d.2", groupId), fmt.Sprintf("!G%d.2", groupId))
+func dbCompleteGroup(db DBTX, groupId int) ([]Advance, *Standing, error) {
+	var winners []Advance
+	var standing *Standing
+	var result error
+	err := DBTransaction(db, func(tx DBTX) error {
+		// a group still waiting for players of other groups cannot be decided
+		var waiting int
+		err := tx.QueryRow("SELECT count(*) FROM matches WHERE group_id = ? AND (source1_group IS NOT NULL OR source2_group IS NOT NULL OR wildcard1 > 0 OR wildcard2 > 0)", groupId).Scan(&waiting)
 		if err != nil {
-			return nil, nil, err
+			return err
 		}
-		defer rows.Close()
-		if !rows.Next() {
-			return nil, nil, fmt.Errorf(i18n[lang]["err-group-complete"] + "no second group found")
+		if waiting > 0 {
+			return errors.New(i18n[DBTournamentLang(tx, DBGroupTournament(tx, groupId))]["err-group-waiting"])
 		}
-		err = rows.Scan(&nextGroupB.Id, &nextGroupB.Name)
-		if err != nil {
-			return nil, nil, err
+		winners, standing, result = dbDoGroupComplete(tx, groupId)
+		var tiebreak *TiebreakError
+		if errors.As(result, &tiebreak) {
+			return nil
 		}
-		rows.Close()
-	}
-
-	standing, err := DBCalcWinner(db, groupId)
+		return result
+	})
 	if err != nil {
 		return nil, nil, err
 	}
+	return winners, standing, result
+}
 
-	// mark group as complete, will have failed if there is no winner in the previous step
-	_, err = db.Exec("UPDATE groups SET complete = 1 WHERE id = ?", groupId)
+func dbDoGroupComplete(db DBTX, groupId int) ([]Advance, *Standing, error) {
+	tournamentId := DBGroupTournament(db, groupId)
+	winners, standing, err := DBGetFormat(db, tournamentId).Complete(db, groupId)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	// advance player to next group
-	_, err = db.Exec("UPDATE participants SET group_id = ? WHERE ign = ?", nextGroupA.Id, standing.First)
+	// players who now face a bye advance without playing
+	err = DBResolveByes(db, tournamentId)
 	if err != nil {
 		return nil, nil, err
 	}
-	if nextGroupB.Id > 0 {
-		_, err = db.Exec("UPDATE participants SET group_id = ? WHERE ign = ?", nextGroupB.Id, standing.Second)
+	if len(winners) > 0 && winners[0].Group.Id == 0 {
+		err = DBCloseTournament(db, tournamentId, winners[0].Player)
 		if err != nil {
 			return nil, nil, err
 		}
 	}
-	// update player id in match
-	_, err = db.Exec("UPDATE matches SET player1 = ? WHERE player1 = ?", standing.First, fmt.Sprintf("!G%d", groupId))
+	return winners, standing, nil
+}
+
+// DBAdvance puts the player reaching a rank in a group into the match waiting for that player, and returns the group of that match.
+// The returned group has id 0 if no match is waiting.
+func DBAdvance(db DBTX, groupId, rank int, player string) (Group, error) {
+	return dbFillSlot(db, DBGroupTournament(db, groupId), player, "source%[1]d_group = ? AND source%[1]d_rank = ?", groupId, rank)
+}
+
+// dbFillSlot puts a player, or a bye, into the slots of a tournament matching the condition, where %[1]d is the side of the slot.
+// It returns the group of the match, which has id 0 if no slot matches.
+func dbFillSlot(db DBTX, tournamentId int, player, condition string, args ...any) (Group, error) {
+	var next Group
+	either := "(" + fmt.Sprintf(condition, 1) + ") OR (" + fmt.Sprintf(condition, 2) + ")"
+	err := db.QueryRow("SELECT g.id, g.name FROM matches m JOIN groups g ON m.group_id = g.id WHERE g.tournament_id = ? AND ("+either+")",
+		append(append([]any{tournamentId}, args...), args...)...).Scan(&next.Id, &next.Name)
+	if err == sql.ErrNoRows {
+		return next, nil
+	}
 	if err != nil {
-		return nil, nil, err
+		return next, err
 	}
-	_, err = db.Exec("UPDATE matches SET player2 = ? WHERE player2 = ?", standing.First, fmt.Sprintf("!G%d", groupId))
+	_, err = db.Exec("UPDATE participants SET group_id = ? WHERE ign = ? AND tournament_id = ?", next.Id, player, tournamentId)
 	if err != nil {
-		return nil, nil, err
+		return next, err
 	}
-	if nextGroupB.Id > 0 {
-		_, err = db.Exec("UPDATE matches SET player1 = ? WHERE player1 = ?", standing.Second, fmt.Sprintf("!G%d.2", groupId))
+	playerId := dbParticipantId(db, tournamentId, player)
+	for side := 1; side <= 2; side++ {
+		_, err = db.Exec(fmt.Sprintf("UPDATE matches SET player%[1]d_id = ?, source%[1]d_group = NULL, source%[1]d_rank = 0, wildcard%[1]d = 0 WHERE group_id IN (SELECT id FROM groups WHERE tournament_id = ?) AND ", side)+fmt.Sprintf(condition, side),
+			append([]any{playerId, tournamentId}, args...)...)
 		if err != nil {
-			return nil, nil, err
+			return next, err
+		}
+	}
+	return next, nil
+}
+
+// dbWildcardRank is the rank whose player competes for a wildcard, if the group takes part in that, otherwise 0
+func dbWildcardRank(db DBTX, groupId int) int {
+	tournamentId := DBGroupTournament(db, groupId)
+	for _, id := range strings.Split(DBGetOption(db, tournamentId, "wildcard-groups"), ",") {
+		if id == strconv.Itoa(groupId) {
+			rank, _ := strconv.Atoi(DBGetOption(db, tournamentId, "wildcard-rank"))
+			return rank
+		}
+	}
+	return 0
+}
+
+// DBResolveWildcards hands out the wildcards once all groups competing for them are complete.
+// The players reaching the wildcard rank in these groups are compared by wins, score difference and points per match,
+// as the groups may differ in size.
+func DBResolveWildcards(db DBTX, tournamentId int) error {
+	option := DBGetOption(db, tournamentId, "wildcard-groups")
+	if option == "error" || option == "" {
+		return nil
+	}
+	var ids []int
+	for _, id := range strings.Split(option, ",") {
+		groupId, err := strconv.Atoi(id)
+		if err != nil {
+			return err
 		}
-		_, err = db.Exec("UPDATE matches SET player2 = ? WHERE player2 = ?", standing.Second, fmt.Sprintf("!G%d.2", groupId))
+		var complete int
+		err = db.QueryRow("SELECT complete FROM groups WHERE id = ?", groupId).Scan(&complete)
 		if err != nil {
-			return nil, nil, err
+			return err
+		}
+		if complete == 0 {
+			return nil
 		}
+		ids = append(ids, groupId)
 	}
-	winners := []Advance{{Player: standing.First, Group: nextGroupA}}
-	if nextGroupB.Id > 0 {
-		winners = append(winners, Advance{Player: standing.Second, Group: nextGroupB})
+
+	rank, _ := strconv.Atoi(DBGetOption(db, tournamentId, "wildcard-rank"))
+	type candidate struct {
+		Rank
+		matches int
+	}
+	var candidates []candidate
+	for _, groupId := range ids {
+		ranks, err := DBGetRanking(db, groupId)
+		if err != nil {
+			return err
+		}
+		if len(ranks) < rank {
+			continue
+		}
+		c := candidate{Rank: ranks[rank-1]}
+		matches, err := dbQueryMatches(db, "WHERE m.group_id = ? AND m.tiebreak = 0", groupId)
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			if m.Player1 == c.Player || m.Player2 == c.Player {
+				c.matches++
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		for _, values := range [][2]int{{a.Wins, b.Wins}, {a.Diff, b.Diff}, {a.Points, b.Points}} {
+			// compares values[0] / a.matches to values[1] / b.matches
+			if x, y := values[0]*b.matches, values[1]*a.matches; x != y {
+				return x > y
+			}
+		}
+		return false
+	})
+
+	for w := 1; ; w++ {
+		// not enough candidates, the slot becomes a bye
+		player := Bye
+		if w <= len(candidates) {
+			player = candidates[w-1].Player
+		}
+		next, err := dbFillSlot(db, tournamentId, player, "wildcard%[1]d = ?", w)
+		if err != nil {
+			return err
+		}
+		if next.Id == 0 {
+			break
+		}
 	}
-	return winners, &standing, nil
+	return DBSetOption(db, tournamentId, "wildcard-groups", "")
 }
 
-func DBCloseTournament(db *sql.DB, winner string) error {
-	_, err := db.Exec("UPDATE options SET value = ? WHERE key = 'status'", "status-finished")
-	if err != nil {
-		return err
+// DBResolveByes completes all knockout groups where a player meets a bye, until no more byes are left to resolve
+func DBResolveByes(db DBTX, tournamentId int) error {
+	for {
+		var groupId int
+		var bye1, bye2 bool
+		var bestof int64
+		// a slot without player is a bye, once it does not wait for a player anymore
+		err := db.QueryRow(`SELECT g.id, m.player1_id IS NULL, m.player2_id IS NULL, m.bestof FROM groups g JOIN matches m ON m.group_id = g.id
+			WHERE g.complete = 0 AND g.tournament_id = ? AND (m.player1_id IS NULL OR m.player2_id IS NULL)
+			AND m.source1_group IS NULL AND m.source2_group IS NULL AND m.wildcard1 = 0 AND m.wildcard2 = 0
+			AND (SELECT count(*) FROM matches WHERE group_id = g.id) = 1`, tournamentId).Scan(&groupId, &bye1, &bye2, &bestof)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// if two byes meet, the first one advances
+		walkover := bestof/2 + 1
+		if bye1 && !bye2 {
+			_, err = db.Exec("UPDATE matches SET score1 = 0, score2 = ? WHERE group_id = ?", walkover, groupId)
+		} else {
+			_, err = db.Exec("UPDATE matches SET score1 = ?, score2 = 0 WHERE group_id = ?", walkover, groupId)
+		}
+		if err != nil {
+			return err
+		}
+		_, _, err = DBGetFormat(db, tournamentId).Complete(db, groupId)
+		if err != nil {
+			return err
+		}
 	}
-	_, err = db.Exec("INSERT INTO options (key, value) VALUES ('winner', ?)", winner)
-	if err != nil {
+}
+
+func DBCloseTournament(db DBTX, tournamentId int, winner string) error {
+	return DBTransaction(db, func(tx DBTX) error {
+		err := DBSetOption(tx, tournamentId, "status", "status-finished")
+		if err != nil {
+			return err
+		}
+		err = DBSetOption(tx, tournamentId, "winner", winner)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE tournaments SET winner = ?, finished = CURRENT_TIMESTAMP WHERE id = ?", winner, tournamentId)
 		return err
+	})
+}
+
+// TournamentInfo summarizes a tournament of the history
+type TournamentInfo struct {
+	Id           int
+	Name         string
+	Created      string
+	Participants int
+	Winner       string
+	Status       string
+}
+
+// DBGetTournaments lists the tournaments of a guild, latest first
+func DBGetTournaments(db DBTX, guildId string, limit int) ([]TournamentInfo, error) {
+	rows, err := db.Query(`SELECT t.id, t.name, date(t.created), coalesce(t.winner, ''),
+		(SELECT count(*) FROM participants p WHERE p.tournament_id = t.id),
+		coalesce((SELECT value FROM options o WHERE o.tournament_id = t.id AND o.key = 'status'), '')
+		FROM tournaments t WHERE t.guild_id = ? ORDER BY t.id DESC LIMIT ?`, guildId, limit)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+	var tournaments []TournamentInfo
+	for rows.Next() {
+		var t TournamentInfo
+		err = rows.Scan(&t.Id, &t.Name, &t.Created, &t.Winner, &t.Participants, &t.Status)
+		if err != nil {
+			return nil, err
+		}
+		tournaments = append(tournaments, t)
+	}
+	return tournaments, nil
 }
 
-func DBGetTournamentName(db *sql.DB) string {
-	return DBGetOption(db, "name")
+func DBGetTournamentName(db DBTX, tournamentId int) string {
+	return DBGetOption(db, tournamentId, "name")
 }
 
-func DBGetTournamentWinner(db *sql.DB) string {
-	return DBGetOption(db, "winner")
+func DBGetTournamentWinner(db DBTX, tournamentId int) string {
+	return DBGetOption(db, tournamentId, "winner")
 }
 
-func DBGetTournamentStatus(db *sql.DB) string {
-	return DBGetOption(db, "status")
+func DBGetTournamentStatus(db DBTX, tournamentId int) string {
+	return DBGetOption(db, tournamentId, "status")
 }
 
-func DBGetGroupByName(db *sql.DB, name string) (int, error) {
+func DBGetGroupByName(db DBTX, tournamentId int, name string) (int, error) {
 	var id int
-	err := db.QueryRow("SELECT id FROM groups WHERE name = ?", name).Scan(&id)
+	err := db.QueryRow("SELECT id FROM groups WHERE name = ? AND tournament_id = ?", name, tournamentId).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
@@ -2,66 +2,74 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"math/rand"
-	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-func InitDB() *sql.DB {
-	db, err := sql.Open("sqlite", "testing.sqlite3")
+// testGuild is the guild of the tournaments created by the tests
+const testGuild = "guild"
+
+// InitDB opens a new database for a test, which is removed with the temporary directory of the test
+func InitDB(t *testing.T) *sql.DB {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "testing.sqlite3"))
 	if err != nil {
-		fmt.Println("error opening database", err)
-		return nil
+		t.Fatalf("Error opening database: %s", err)
+	}
+	err = DBMigrate(db)
+	if err != nil {
+		t.Fatalf("Error migrating database: %s", err)
 	}
-
 	return db
 }
 
 func TestTournament(t *testing.T) {
-	db := InitDB()
+	db := InitDB(t)
 	defer db.Close()
-
-	if db == nil {
-		t.Errorf("Error opening database")
-	}
 	// reset the tournament with a random id
 	randName := fmt.Sprintf("test-%d", rand.Intn(1000))
-	DBResetTournament(db, randName)
+	tid, _ := DBResetTournament(db, testGuild, randName)
 
-	name := DBGetTournamentName(db)
+	name := DBGetTournamentName(db, tid)
 	if name != randName {
 		t.Errorf("Expected tournament name %s, got %s", randName, name)
 	}
 
 	//register 21 participants
 	for i := 0; i < 21; i++ {
-		DBRegisterParticipant(db, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
 	}
 
 	// check if 21 participants are registered
-	participants := DBGetParticipants(db, 0)
+	participants := DBGetParticipants(db, tid, 0)
 	if len(participants) != 21 {
 		t.Errorf("Expected 21 participants, got %d", len(participants))
 	}
 
 	// start the tournament with group size 4
-	DBStartTournament(db, 4, 3, 5)
+	DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 4, BestOf: 3, Finals: 5})
 
 	// check if the tournament is started
-	status := DBGetTournamentStatus(db)
+	status := DBGetTournamentStatus(db, tid)
 	if status != "status-started" {
 		t.Errorf("Expected status-started, got %s", status)
 	}
 
 	// check if the tournament has 4 active groups, this tests group count reduction to 2^x
-	groups := DBGetGroups(db)
+	groups := DBGetGroups(db, tid)
 	if len(groups) != 4 {
 		t.Errorf("Expected 4 groups, got %d", len(groups))
 	}
 
-	// check if group 1 is complete
-	advance, _, err := DBCheckGroupComplete(db, 1)
+	// check if the first group is complete
+	advance, _, err := DBCheckGroupComplete(db, groups[0].Id)
 	if err != nil {
 		t.Errorf("Error checking group 1: %s", err)
 	}
@@ -74,14 +82,14 @@ func TestTournament(t *testing.T) {
 		for _, p1 := range group.Participants {
 			for _, p2 := range group.Participants {
 				if p1 != p2 {
-					DBCreateMatch(db, p1, p2, 3, 2)
+					DBCreateMatch(db, tid, p1, p2, 3, 2)
 				}
 			}
 		}
 	}
 
-	// check if group 1 is complete
-	advance, _, err = DBCheckGroupComplete(db, 1)
+	// check if the first group is complete
+	advance, _, err = DBCheckGroupComplete(db, groups[0].Id)
 	if err != nil {
 		t.Errorf("Error checking group 1: %s", err)
 	}
@@ -95,17 +103,18 @@ func TestTournament(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error checking group %d: %s", group.Id, err)
 		}
-		if group.Id == 1 && advance != nil {
+		if group.Id == groups[0].Id && advance != nil {
 			t.Errorf("Expected group 1 to be skipped")
-		} else if group.Id > 1 && len(advance) < 2 {
+		} else if group.Id != groups[0].Id && len(advance) < 2 {
 			t.Errorf("Expected group %d to be complete and have at least 2 winners", group.Id)
 		}
 	}
 
 	// group 5 is critical, because the sizes of the incoming groups mismatch, check it has two participants. we also call checkgroup twice
-	groups = DBGetGroups(db)
+	fifth := groups[0].Id + 4
+	groups = DBGetGroups(db, tid)
 	for _, group := range groups {
-		if group.Id == 5 {
+		if group.Id == fifth {
 			if len(group.Participants) != 2 {
 				t.Errorf("Expected group 5 to have 2 participants, got %d", len(group.Participants))
 			}
@@ -113,7 +122,7 @@ func TestTournament(t *testing.T) {
 	}
 
 	// check if the tournament is finished
-	status = DBGetTournamentStatus(db)
+	status = DBGetTournamentStatus(db, tid)
 	if status != "status-started" {
 		t.Errorf("Expected status-started, got %s", status)
 	}
@@ -123,7 +132,7 @@ func TestTournament(t *testing.T) {
 	fistScore := 4
 	secondScore := 2
 	for count < 20 {
-		groups = DBGetGroups(db)
+		groups = DBGetGroups(db, tid)
 		if len(groups) == 1 {
 			break
 		}
@@ -132,7 +141,7 @@ func TestTournament(t *testing.T) {
 			for _, p1 := range group.Participants {
 				for _, p2 := range group.Participants {
 					if p1 != p2 {
-						DBCreateMatch(db, p1, p2, int64(score), 0)
+						DBCreateMatch(db, tid, p1, p2, int64(score), 0)
 						score = secondScore
 					}
 				}
@@ -149,13 +158,13 @@ func TestTournament(t *testing.T) {
 	}
 
 	// check if the tournament is finished
-	status = DBGetTournamentStatus(db)
+	status = DBGetTournamentStatus(db, tid)
 	if status != "status-started" {
 		t.Errorf("Expected status-started, got %s", status)
 	}
 
 	// close the finals
-	groups = DBGetGroups(db)
+	groups = DBGetGroups(db, tid)
 	if len(groups) != 1 {
 		t.Errorf("Expected 1 group, got %d", len(groups))
 	}
@@ -163,7 +172,7 @@ func TestTournament(t *testing.T) {
 		t.Errorf("Expected 2 participants, got %d", len(groups[0].Participants))
 	}
 
-	DBCreateMatch(db, groups[0].Participants[0], groups[0].Participants[1], 3, 2)
+	DBCreateMatch(db, tid, groups[0].Participants[0], groups[0].Participants[1], 3, 2)
 	advance, _, _ = DBCheckGroupComplete(db, groups[0].Id)
 
 	if len(advance) != 1 {
@@ -173,68 +182,60 @@ func TestTournament(t *testing.T) {
 		t.Errorf("Expected winner to be promoted to group 0, got %d", advance[0].Group.Id)
 	}
 
-	DBCloseTournament(db, advance[0].Player)
+	DBCloseTournament(db, tid, advance[0].Player)
 
 	// check if the tournament is finished
-	status = DBGetTournamentStatus(db)
+	status = DBGetTournamentStatus(db, tid)
 	if status != "status-finished" {
 		t.Errorf("Expected status-finished, got %s", status)
 	}
 
 	// read winner
-	winner := DBGetTournamentWinner(db)
+	winner := DBGetTournamentWinner(db, tid)
 	if winner != advance[0].Player {
 		t.Errorf("Expected winner %s, got %s", advance[0].Player, winner)
 	}
-
-	//finally, close and delete the database file
-	db.Close()
-	os.Remove("testing.sqlite3")
 }
 
 func TestGroupSize2(t *testing.T) {
-	db := InitDB()
+	db := InitDB(t)
 	defer db.Close()
-
-	if db == nil {
-		t.Errorf("Error opening database")
-	}
 	// reset the tournament with a random id
 	randName := fmt.Sprintf("test-%d", rand.Intn(1000))
-	DBResetTournament(db, randName)
+	tid, _ := DBResetTournament(db, testGuild, randName)
 
-	name := DBGetTournamentName(db)
+	name := DBGetTournamentName(db, tid)
 	if name != randName {
 		t.Errorf("Expected tournament name %s, got %s", randName, name)
 	}
 
 	//register 8 participants
 	for i := 0; i < 8; i++ {
-		DBRegisterParticipant(db, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
 	}
 
 	// check if 8 participants are registered
-	participants := DBGetParticipants(db, 0)
+	participants := DBGetParticipants(db, tid, 0)
 	if len(participants) != 8 {
 		t.Errorf("Expected 8 participants, got %d", len(participants))
 	}
 
 	// start the tournament with group size 2
-	DBStartTournament(db, 2, 3, 5)
+	DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 2, BestOf: 3, Finals: 5})
 
 	// check if the tournament is started
-	status := DBGetTournamentStatus(db)
+	status := DBGetTournamentStatus(db, tid)
 	if status != "status-started" {
 		t.Errorf("Expected status-started, got %s", status)
 	}
 
 	// check if the tournament has 4 active groups, this tests group count reduction to 2^x
-	groups := DBGetGroups(db)
+	groups := DBGetGroups(db, tid)
 	if len(groups) != 4 {
 		t.Errorf("Expected 4 groups, got %d", len(groups))
 	}
 
-	group, bestof := DBGetGroupAndBestOf(db, groups[0].Participants[0], groups[0].Participants[1])
+	group, bestof := DBGetGroupAndBestOf(db, tid, groups[0].Participants[0], groups[0].Participants[1])
 	if group.Id == 0 {
 		t.Errorf("Expected group 1 to be created")
 	}
@@ -243,10 +244,10 @@ func TestGroupSize2(t *testing.T) {
 	}
 
 	// generate a match for first group
-	DBCreateMatch(db, groups[0].Participants[0], groups[0].Participants[1], 3, 2)
+	DBCreateMatch(db, tid, groups[0].Participants[0], groups[0].Participants[1], 3, 2)
 
-	// check if group 1 is complete, this seems to currently throw an error trying to advance the second player
-	advance, _, err := DBCheckGroupComplete(db, 1)
+	// check if the first group is complete
+	advance, _, err := DBCheckGroupComplete(db, groups[0].Id)
 	if err != nil {
 		t.Errorf("Error checking group 1: %s", err)
 	}
@@ -254,3 +255,1534 @@ func TestGroupSize2(t *testing.T) {
 		t.Errorf("Expected group 1 to be complete")
 	}
 }
+
+func TestSwiss(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "swiss")
+
+	//register 9 participants, so every round has a bye
+	for i := 0; i < 9; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+
+	err := DBStartTournament(db, tid, Settings{Format: "swiss", GroupSize: 2, BestOf: 3, Finals: 3})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+
+	// 9 players need 4 rounds
+	var advance []Advance
+	pairings := make(map[string]bool)
+	for round := 1; round <= 4; round++ {
+		groups := DBGetGroups(db, tid)
+		if len(groups) != 1 {
+			t.Fatalf("Expected 1 active round, got %d", len(groups))
+		}
+		if len(groups[0].Participants) != 9 {
+			t.Errorf("Expected all 9 players in round %d, got %d", round, len(groups[0].Participants))
+		}
+		matches := DBGetMatches(db, tid, groups[0].Id)
+		if len(matches) != 5 {
+			t.Errorf("Expected 4 matches and a bye in round %d, got %d", round, len(matches))
+		}
+		for _, m := range matches {
+			if m.Player2 == Bye {
+				if m.Score1 != 2 {
+					t.Errorf("Expected bye to be won 2-0, got %d", m.Score1)
+				}
+				continue
+			}
+			key := m.Player1 + ":" + m.Player2
+			if m.Player2 < m.Player1 {
+				key = m.Player2 + ":" + m.Player1
+			}
+			if pairings[key] {
+				t.Errorf("Rematch %s in round %d", key, round)
+			}
+			pairings[key] = true
+			DBCreateMatch(db, tid, m.Player1, m.Player2, 2, 1)
+		}
+		advance, _, err = DBCheckGroupComplete(db, groups[0].Id)
+		if err != nil {
+			t.Fatalf("Error completing round %d: %s", round, err)
+		}
+		if round < 4 && len(advance) != 0 {
+			t.Errorf("Expected nobody to advance after round %d", round)
+		}
+	}
+
+	if len(advance) != 1 || advance[0].Group.Id != 0 {
+		t.Fatalf("Expected a tournament winner after the last round, got %v", advance)
+	}
+	order, scores, _ := SwissScores(db, tid)
+	if advance[0].Player != order[0] {
+		t.Errorf("Expected winner %s, got %s", order[0], advance[0].Player)
+	}
+	if scores[order[0]].Wins < scores[order[1]].Wins {
+		t.Errorf("Expected standings to be ordered by wins")
+	}
+	if len(DBGetGroups(db, tid)) != 0 {
+		t.Errorf("Expected no open rounds after the tournament")
+	}
+}
+
+func TestSwissPairsLimit(t *testing.T) {
+	// the last three players have played everyone but each other, so the other 39 cannot be paired among themselves,
+	// which a full search only finds out after trying all their pairings
+	var players []string
+	for k := 0; k < 42; k++ {
+		players = append(players, fmt.Sprintf("p%02d", k))
+	}
+	played := make(map[string]map[string]bool)
+	for _, p := range players {
+		played[p] = make(map[string]bool)
+	}
+	for _, p := range players[39:] {
+		for _, q := range players[:39] {
+			played[p][q], played[q][p] = true, true
+		}
+	}
+	start := time.Now()
+	if _, ok := swissPairs(players, played); ok {
+		t.Errorf("Expected no pairing without rematches")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the search to give up, it took %s", elapsed)
+	}
+}
+
+func TestSwissTiebreak(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "swiss-tie")
+	for i := 0; i < 4; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	err := DBStartTournament(db, tid, Settings{Format: "swiss", BestOf: 3, Finals: 3, Rounds: 1, DrawSeed: 1})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+
+	// both winners of the only round are tied on wins, Buchholz and score difference
+	round := DBGetGroups(db, tid)[0]
+	var leaders []string
+	for _, m := range DBGetMatches(db, tid, round.Id) {
+		DBCreateMatch(db, tid, m.Player1, m.Player2, 2, 0)
+		leaders = append(leaders, m.Player1)
+	}
+	_, _, err = DBCheckGroupComplete(db, round.Id)
+	var tiebreak *TiebreakError
+	if !errors.As(err, &tiebreak) || len(tiebreak.Matches) != 1 {
+		t.Fatalf("Expected a tiebreak match between the leaders, got %v", err)
+	}
+	if DBGetTournamentStatus(db, tid) != "status-started" {
+		t.Errorf("Expected the tournament to go on until the tie is broken")
+	}
+
+	// the tiebreak match decides the winner, the swiss table stays as it was
+	DBCreateMatch(db, tid, leaders[1], leaders[0], 2, 1)
+	advance, standing, err := DBCheckGroupComplete(db, round.Id)
+	if err != nil {
+		t.Fatalf("Error completing the last round: %s", err)
+	}
+	if len(advance) != 1 || advance[0].Player != leaders[1] || advance[0].Group.Id != 0 {
+		t.Errorf("Expected %s to win the tournament, got %v", leaders[1], advance)
+	}
+	if standing.WinBy[0] != WinByTiebreak {
+		t.Errorf("Expected the tie to be broken by the tiebreak match, got %v", standing.WinBy)
+	}
+	if _, scores, _ := SwissScores(db, tid); scores[leaders[1]].Wins != 1 || scores[leaders[0]].Wins != 1 {
+		t.Errorf("Expected one regular win for both leaders, got %+v", scores)
+	}
+}
+
+func TestDoubleElimination(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "double")
+
+	//register 6 participants, so two of them start with a bye
+	for i := 0; i < 6; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+
+	err := DBStartTournament(db, tid, Settings{Format: "double", GroupSize: 2, BestOf: 3, Finals: 5, Reset: true})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+
+	// the first player always wins, except in the grand final, to force a bracket reset
+	losses := make(map[string]int)
+	played := 0
+	var advance []Advance
+	for count := 0; count < 20 && (len(advance) == 0 || advance[0].Group.Id != 0); count++ {
+		games, err := DBGetAllGames(db, tid)
+		if err != nil {
+			t.Fatalf("Error getting games: %s", err)
+		}
+		for _, group := range games {
+			m := group.Matches[0]
+			if m.Player1[0] == '!' || m.Player2[0] == '!' {
+				continue
+			}
+			score1, score2 := int64(2), int64(1)
+			if group.Name == i18n[defaultLang]["bracket-final"] {
+				score1, score2 = 1, 3
+				losses[m.Player1]++
+			} else {
+				losses[m.Player2]++
+			}
+			DBCreateMatch(db, tid, m.Player1, m.Player2, score1, score2)
+			played++
+			advance, _, err = DBCheckGroupComplete(db, group.Id)
+			if err != nil {
+				t.Fatalf("Error completing %s: %s", group.Name, err)
+			}
+			if len(advance) == 0 {
+				t.Fatalf("Expected %s to be complete", group.Name)
+			}
+			if advance[0].Group.Id == 0 {
+				break
+			}
+		}
+	}
+
+	if len(advance) != 1 || advance[0].Group.Id != 0 {
+		t.Fatalf("Expected a tournament winner, got %v", advance)
+	}
+	// 2n-2 matches, plus the reset of the grand final
+	if played != 11 {
+		t.Errorf("Expected 11 matches, got %d", played)
+	}
+	for p, l := range losses {
+		if p == advance[0].Player && l != 1 {
+			t.Errorf("Expected winner %s to have lost once, got %d", p, l)
+		} else if p != advance[0].Player && l != 2 {
+			t.Errorf("Expected %s to be eliminated after two losses, got %d", p, l)
+		}
+	}
+	if len(losses) != 6 {
+		t.Errorf("Expected all 6 players to lose at least once, got %d", len(losses))
+	}
+}
+
+func TestSingleElimination(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "single")
+
+	//register 5 participants, three of them get a bye in the first round
+	for i := 0; i < 5; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+
+	err := DBStartTournament(db, tid, Settings{Format: "single", GroupSize: 2, BestOf: 3, Finals: 5})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+
+	// only the match without a bye is open, the other players already wait in the second round
+	groups := DBGetGroups(db, tid)
+	if len(groups) != 3 {
+		t.Fatalf("Expected 3 active groups, got %d", len(groups))
+	}
+	games, _ := DBGetAllGames(db, tid)
+	open := 0
+	for _, group := range games {
+		m := group.Matches[0]
+		if m.Player1[0] != '!' && m.Player2[0] != '!' {
+			open++
+		}
+	}
+	if open != 2 {
+		t.Errorf("Expected 2 playable matches, got %d", open)
+	}
+
+	// n-1 matches to find a winner
+	played := 0
+	var advance []Advance
+	for count := 0; count < 10 && (len(advance) == 0 || advance[0].Group.Id != 0); count++ {
+		games, _ = DBGetAllGames(db, tid)
+		for _, group := range games {
+			m := group.Matches[0]
+			if m.Player1[0] == '!' || m.Player2[0] == '!' {
+				continue
+			}
+			DBCreateMatch(db, tid, m.Player1, m.Player2, 2, 0)
+			played++
+			advance, _, err = DBCheckGroupComplete(db, group.Id)
+			if err != nil {
+				t.Fatalf("Error completing %s: %s", group.Name, err)
+			}
+		}
+	}
+	if len(advance) != 1 || advance[0].Group.Id != 0 {
+		t.Fatalf("Expected a tournament winner, got %v", advance)
+	}
+	if played != 4 {
+		t.Errorf("Expected 4 matches, got %d", played)
+	}
+}
+
+func TestFillKnockout(t *testing.T) {
+	// 15 players in groups of 3 make 5 groups, whose winners leave 3 free slots in a bracket of 8
+	expected := map[string]int{"byes": 15 + 4, "wildcards": 15 + 7}
+	for fill, matches := range expected {
+		db := InitDB(t)
+		tid, _ := DBResetTournament(db, testGuild, fill)
+		for i := 0; i < 15; i++ {
+			DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+		}
+		err := DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 3, BestOf: 3, Finals: 3, Fill: fill})
+		if err != nil {
+			t.Fatalf("Error starting tournament: %s", err)
+		}
+		groups := DBGetGroups(db, tid)
+		if len(groups) != 5 {
+			t.Errorf("Expected 5 groups with %s, got %d", fill, len(groups))
+		}
+
+		played := 0
+		var advance []Advance
+		for count := 0; count < 20 && (len(advance) == 0 || advance[0].Group.Id != 0); count++ {
+			games, _ := DBGetAllGames(db, tid)
+			for _, group := range games {
+				for _, m := range group.Matches {
+					if m.Player1[0] == '!' || m.Player2[0] == '!' || m.Score1+m.Score2 > 0 {
+						continue
+					}
+					DBCreateMatch(db, tid, m.Player1, m.Player2, 2, 1)
+					played++
+				}
+				advance, _, err = DBCheckGroupComplete(db, group.Id)
+				if err != nil {
+					t.Fatalf("Error completing %s: %s", group.Name, err)
+				}
+				if len(advance) > 0 && advance[0].Group.Id == 0 {
+					break
+				}
+			}
+		}
+		if len(advance) != 1 || advance[0].Group.Id != 0 {
+			t.Errorf("Expected a tournament winner with %s, got %v", fill, advance)
+		}
+		if played != matches {
+			t.Errorf("Expected %d matches with %s, got %d", matches, fill, played)
+		}
+		db.Close()
+	}
+}
+
+func TestCheckRanking(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	// 8 players in groups of 4, the first two of each group advance
+	tid, _ := DBResetTournament(db, testGuild, "ranking")
+	for i := 0; i < 8; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	err := DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 4, BestOf: 3, Finals: 3, Advance: 2})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	group := DBGetGroups(db, tid)[0]
+	if places := dbAdvancingPlaces(db, group.Id); places != 2 {
+		t.Errorf("Expected 2 places to advance, got %d", places)
+	}
+	if err = checkRanking(db, group.Id, Standing{Ranking: group.Participants[:1]}); err == nil {
+		t.Errorf("Expected a ranking of one player to be refused")
+	}
+	if err = checkRanking(db, group.Id, Standing{Ranking: group.Participants[:2]}); err != nil {
+		t.Errorf("Expected a ranking of two players to be accepted, got %s", err)
+	}
+}
+
+func TestCloseByeGroupEarly(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	// 15 players in groups of 3 make 5 groups, three group winners meet a bye in the bracket of 8
+	tid, _ := DBResetTournament(db, testGuild, "early")
+	for i := 0; i < 15; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	err := DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 3, BestOf: 3, Finals: 3, Fill: "byes"})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	games, _ := DBGetAllGames(db, tid)
+	var bye Group
+	for _, group := range games {
+		for _, m := range group.Matches {
+			if m.Player1[0] == '!' && m.Player2 == Bye {
+				if m.Score1+m.Score2 > 0 {
+					t.Errorf("Expected no walkover before the player is known, got %v", m)
+				}
+				bye = group
+			}
+		}
+	}
+	if bye.Id == 0 {
+		t.Fatalf("Expected a group waiting for a player against a bye, got %v", games)
+	}
+	// closing the group before its player is known fails and leaves it open
+	if _, _, err = DBDoGroupComplete(db, bye.Id); err == nil || err.Error() != i18n[defaultLang]["err-group-waiting"] {
+		t.Errorf("Expected the group to wait for its player, got %v", err)
+	}
+	// neither can the final be closed before both finalists are known
+	final := games[len(games)-1]
+	if _, _, err = DBDoGroupComplete(db, final.Id); err == nil || err.Error() != i18n[defaultLang]["err-group-waiting"] {
+		t.Errorf("Expected the final to wait for its players, got %v", err)
+	}
+	open := make(map[int]bool)
+	games, _ = DBGetAllGames(db, tid)
+	for _, group := range games {
+		open[group.Id] = true
+	}
+	if !open[bye.Id] || !open[final.Id] {
+		t.Errorf("Expected %s and %s to stay open", bye.Name, final.Name)
+	}
+}
+
+func TestWildcards(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	// 10 players in groups of 3 make groups of 4, 3 and 3, the best second place gets the free slot in a bracket of 4
+	tid, _ := DBResetTournament(db, testGuild, "wildcards")
+	for i := 0; i < 10; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	err := DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 3, BestOf: 3, Finals: 3, Fill: "wildcards", DrawSeed: 1})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	groups := DBGetGroups(db, tid)
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].Participants) > len(groups[j].Participants) })
+	if len(groups) != 3 || len(groups[0].Participants) != 4 {
+		t.Fatalf("Expected a group of 4 and two groups of 3, got %v", groups)
+	}
+	large, small := groups[0].Participants, groups[1:]
+
+	// in the large group, the first wins all matches and the others beat each other in a circle
+	for _, p := range large[1:] {
+		DBCreateMatch(db, tid, large[0], p, 2, 1)
+	}
+	DBCreateMatch(db, tid, large[1], large[2], 2, 1)
+	DBCreateMatch(db, tid, large[2], large[3], 2, 1)
+	DBCreateMatch(db, tid, large[3], large[1], 2, 1)
+	// the tie for the wildcard rank is played out instead of being decided by name
+	_, _, err = DBCheckGroupComplete(db, groups[0].Id)
+	var tiebreak *TiebreakError
+	if !errors.As(err, &tiebreak) || tiebreak.Place != 2 || len(tiebreak.Matches) != 3 {
+		t.Fatalf("Expected tiebreak matches for place 2, got %v", err)
+	}
+	DBCreateMatch(db, tid, large[1], large[2], 2, 0)
+	DBCreateMatch(db, tid, large[1], large[3], 2, 0)
+	DBCreateMatch(db, tid, large[2], large[3], 2, 0)
+	if _, _, err = DBCheckGroupComplete(db, groups[0].Id); err != nil {
+		t.Fatalf("Error completing %s: %s", groups[0].Name, err)
+	}
+	if ranks, _ := DBGetRanking(db, groups[0].Id); ranks[1].Player != large[1] {
+		t.Errorf("Expected %s second after the tiebreak, got %v", large[1], ranks)
+	}
+
+	// in the small groups, the second wins one of two matches: fewer wins and points, but more per match
+	for _, g := range small {
+		DBCreateMatch(db, tid, g.Participants[0], g.Participants[1], 2, 0)
+		DBCreateMatch(db, tid, g.Participants[0], g.Participants[2], 2, 0)
+		DBCreateMatch(db, tid, g.Participants[1], g.Participants[2], 2, 1)
+		if _, _, err = DBCheckGroupComplete(db, g.Id); err != nil {
+			t.Fatalf("Error completing %s: %s", g.Name, err)
+		}
+	}
+	wildcard := small[0].Participants[1]
+	var bracket []string
+	for _, g := range DBGetGroups(db, tid) {
+		bracket = append(bracket, g.Participants...)
+	}
+	if !strings.Contains(strings.Join(bracket, ","), wildcard) || strings.Contains(strings.Join(bracket, ","), large[1]) {
+		t.Errorf("Expected %s to get the wildcard instead of %s, got %v", wildcard, large[1], bracket)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "advance")
+	for i := 0; i < 16; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+
+	// everybody advancing is not a qualification
+	err := DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 4, BestOf: 3, Finals: 3, Advance: 4})
+	if err == nil {
+		t.Errorf("Expected an error if all players of a group advance")
+	}
+
+	// two groups of 8, four of each advance
+	err = DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 8, BestOf: 3, Finals: 3, Advance: 4})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	groups := DBGetGroups(db, tid)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	origin := make(map[string]int)
+	for _, group := range groups {
+		for _, p := range group.Participants {
+			origin[p] = group.Id
+		}
+		// the first player of each pairing wins, which ranks the players in alphabetical order
+		for _, m := range DBGetMatches(db, tid, group.Id) {
+			DBCreateMatch(db, tid, m.Player1, m.Player2, 2, 1)
+		}
+		advance, _, err := DBCheckGroupComplete(db, group.Id)
+		if err != nil {
+			t.Fatalf("Error completing %s: %s", group.Name, err)
+		}
+		if len(advance) != 4 {
+			t.Errorf("Expected 4 players to advance from %s, got %d", group.Name, len(advance))
+		}
+	}
+
+	// the first knockout round is cross seeded, nobody meets a player of the same group
+	knockout := DBGetGroups(db, tid)
+	if len(knockout) != 4 {
+		t.Fatalf("Expected 4 knockout matches, got %d", len(knockout))
+	}
+	for _, group := range knockout {
+		if len(group.Participants) != 2 {
+			t.Errorf("Expected 2 participants in %s, got %d", group.Name, len(group.Participants))
+			continue
+		}
+		if origin[group.Participants[0]] == origin[group.Participants[1]] {
+			t.Errorf("Expected %s to be cross seeded, got two players of the same group", group.Name)
+		}
+	}
+}
+
+func TestTiebreakers(t *testing.T) {
+	// everybody wins once: B has the best difference, A and B scored the same points, but A beat B
+	matches := []Match{
+		{Player1: "A", Player2: "B", Score1: 2, Score2: 1},
+		{Player1: "A", Player2: "C", Score1: 1, Score2: 2},
+		{Player1: "B", Player2: "C", Score1: 2, Score2: 0},
+	}
+	scores := CalcScores(matches)
+	players := []string{"A", "B", "C"}
+
+	standing := RankPlayers(players, []string{"wins", "diff"}, scores, matches, 1)
+	if fmt.Sprint(standing.Ranking) != "[B A C]" || standing.WinBy[0] != WinByDiff {
+		t.Errorf("Expected B ahead of A by difference, got %v %v", standing.Ranking, standing.WinBy)
+	}
+
+	standing = RankPlayers(players, []string{"wins", "points", "h2h"}, scores, matches, 1)
+	if fmt.Sprint(standing.Ranking) != "[A B C]" || standing.WinBy[0] != WinByHeadToHead || standing.WinBy[1] != WinByPoints {
+		t.Errorf("Expected A ahead of B by head-to-head, got %v %v", standing.Ranking, standing.WinBy)
+	}
+
+	// all three beat each other once, nothing breaks the tie without a draw
+	standing = RankPlayers(players, []string{"wins", "h2h"}, scores, matches, 1)
+	for k, winBy := range standing.WinBy[:2] {
+		if winBy != 0 {
+			t.Errorf("Expected a tie at position %d, got %v", k+1, winBy)
+		}
+	}
+	standing = RankPlayers(players, []string{"wins", "h2h", "draw"}, scores, matches, 1)
+	for k, winBy := range standing.WinBy[:2] {
+		if winBy != WinByDraw {
+			t.Errorf("Expected a draw at position %d, got %v", k+1, winBy)
+		}
+	}
+	again := RankPlayers(players, []string{"wins", "h2h", "draw"}, scores, matches, 1)
+	if fmt.Sprint(again.Ranking) != fmt.Sprint(standing.Ranking) {
+		t.Errorf("Expected the draw to be repeatable, got %v and %v", standing.Ranking, again.Ranking)
+	}
+
+	if _, err := ParseTiebreakers("wins,luck", defaultLang); err == nil {
+		t.Errorf("Expected unknown tiebreaker to fail")
+	}
+	if _, err := ParseTiebreakers("wins,diff,wins", defaultLang); err == nil {
+		t.Errorf("Expected duplicate tiebreaker to fail")
+	}
+	if chain, err := ParseTiebreakers(" Wins, H2H ", defaultLang); err != nil || fmt.Sprint(chain) != "[wins h2h]" {
+		t.Errorf("Expected [wins h2h], got %v %v", chain, err)
+	}
+}
+
+func TestRanking(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "ranking")
+	for i := 0; i < 4; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	err := DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 4, BestOf: 3, Finals: 3})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	group := DBGetGroups(db, tid)[0]
+
+	// nobody has played yet, everybody shares the first position
+	ranks, err := DBGetRanking(db, group.Id)
+	if err != nil {
+		t.Fatalf("Error ranking %s: %s", group.Name, err)
+	}
+	if len(ranks) != 4 {
+		t.Fatalf("Expected 4 ranks, got %d", len(ranks))
+	}
+	for _, r := range ranks {
+		if r.Position != 1 {
+			t.Errorf("Expected %s to share position 1, got %d", r.Player, r.Position)
+		}
+	}
+
+	// the player with the lower number wins each pairing
+	for _, m := range DBGetMatches(db, tid, group.Id) {
+		if m.Player1 < m.Player2 {
+			DBCreateMatch(db, tid, m.Player1, m.Player2, 2, 1)
+		} else {
+			DBCreateMatch(db, tid, m.Player1, m.Player2, 1, 2)
+		}
+	}
+	ranks, err = DBGetRanking(db, group.Id)
+	if err != nil {
+		t.Fatalf("Error ranking %s: %s", group.Name, err)
+	}
+	for k, r := range ranks {
+		player := fmt.Sprintf("ign%d", k)
+		if r.Position != k+1 || r.Player != player {
+			t.Errorf("Expected %s at position %d, got %s at %d", player, k+1, r.Player, r.Position)
+		}
+		if r.Wins != 3-k || r.Points != 2*(3-k)+k || r.Diff != 3-2*k {
+			t.Errorf("Unexpected score for %s: %+v", r.Player, r.Score)
+		}
+		if r.WinBy != WinByWins {
+			t.Errorf("Expected %s to be ranked by wins, got %d", r.Player, r.WinBy)
+		}
+	}
+
+	// the ranking decides the tournament winner
+	_, standing, err := DBCheckGroupComplete(db, group.Id)
+	if err != nil {
+		t.Fatalf("Error completing %s: %s", group.Name, err)
+	}
+	if standing == nil || standing.Ranking[0] != ranks[0].Player {
+		t.Errorf("Expected %s to win the group, got %v", ranks[0].Player, standing)
+	}
+}
+
+func TestTiebreakMatches(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "tiebreak")
+	for i := 0; i < 3; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	err := DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 3, BestOf: 3, Finals: 3})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	group := DBGetGroups(db, tid)[0]
+
+	// everybody wins once by 2-1, nothing breaks the tie
+	DBCreateMatch(db, tid, "ign0", "ign1", 2, 1)
+	DBCreateMatch(db, tid, "ign1", "ign2", 2, 1)
+	DBCreateMatch(db, tid, "ign2", "ign0", 2, 1)
+	_, _, err = DBCheckGroupComplete(db, group.Id)
+	var tiebreak *TiebreakError
+	if !errors.As(err, &tiebreak) {
+		t.Fatalf("Expected tiebreak matches to be scheduled, got %v", err)
+	}
+	if tiebreak.Place != 1 || len(tiebreak.Matches) != 3 {
+		t.Errorf("Expected 3 tiebreak matches for place 1, got %d for place %d", len(tiebreak.Matches), tiebreak.Place)
+	}
+	// closing the group again does not schedule more matches
+	_, _, err = DBDoGroupComplete(db, group.Id)
+	if !errors.As(err, &tiebreak) || len(tiebreak.Matches) != 3 {
+		t.Errorf("Expected the same 3 tiebreak matches, got %v", err)
+	}
+	games, err := DBGetAllGames(db, tid)
+	if err != nil {
+		t.Fatalf("Error getting games: %s", err)
+	}
+	count := 0
+	for _, m := range games[0].Matches {
+		if m.Tiebreak > 0 {
+			count++
+		}
+	}
+	if len(games[0].Matches) != 6 || count != 3 {
+		t.Errorf("Expected 3 regular and 3 tiebreak matches, got %d of %d", count, len(games[0].Matches))
+	}
+
+	// the tiebreak matches tie again, the players meet in another round
+	DBCreateMatch(db, tid, "ign0", "ign1", 2, 0)
+	DBCreateMatch(db, tid, "ign1", "ign2", 2, 0)
+	DBCreateMatch(db, tid, "ign2", "ign0", 2, 0)
+	_, _, err = DBCheckGroupComplete(db, group.Id)
+	if !errors.As(err, &tiebreak) || len(tiebreak.Matches) != 3 || tiebreak.Matches[0].Tiebreak != 2 {
+		t.Fatalf("Expected a second round of 3 tiebreak matches, got %v", err)
+	}
+	matches := DBGetMatches(db, tid, group.Id)
+	if len(matches) != 9 || matches[len(matches)-1].Tiebreak != 2 {
+		t.Fatalf("Expected the matches of both tiebreak rounds, got %v", matches)
+	}
+
+	// the results go to the latest tiebreak matches, the regular results stay as they were
+	DBCreateMatch(db, tid, "ign0", "ign1", 2, 0)
+	DBCreateMatch(db, tid, "ign2", "ign0", 0, 2)
+	_, _, err = DBCheckGroupComplete(db, group.Id)
+	if err != nil {
+		t.Errorf("Expected the group to wait for the last tiebreak match, got %s", err)
+	}
+	DBCreateMatch(db, tid, "ign1", "ign2", 2, 0)
+	scores, _ := DBGetScores(db, group.Id)
+	for p, s := range scores {
+		if s.Wins != 1 || s.Diff != 0 {
+			t.Errorf("Expected the regular score of %s to be unchanged, got %+v", p, s)
+		}
+	}
+	advance, standing, err := DBCheckGroupComplete(db, group.Id)
+	if err != nil {
+		t.Fatalf("Error completing %s: %s", group.Name, err)
+	}
+	if len(advance) != 1 || advance[0].Player != "ign0" || advance[0].Group.Id != 0 {
+		t.Errorf("Expected ign0 to win the tournament, got %v", advance)
+	}
+	if standing.WinBy[0] != WinByTiebreak {
+		t.Errorf("Expected the tie to be broken by the tiebreak matches, got %v", standing.WinBy)
+	}
+}
+
+func TestSeeding(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "seeding")
+	for i := 0; i < 8; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	if err := DBSetSeed(db, tid, "nobody", 1); err == nil {
+		t.Errorf("Expected an error seeding an unknown player")
+	}
+	for i := 0; i < 4; i++ {
+		DBSetSeed(db, tid, fmt.Sprintf("ign%d", i), i+1)
+	}
+
+	// snake draw: seeds 1 and 4 share a group, seeds 2 and 3 the other one
+	err := DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 4, BestOf: 3, Finals: 3})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	groups := DBGetGroups(db, tid)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	in := make(map[string]int)
+	for _, group := range groups {
+		for _, p := range group.Participants {
+			in[p] = group.Id
+		}
+	}
+	if in["ign0"] != in["ign3"] || in["ign1"] != in["ign2"] || in["ign0"] == in["ign1"] {
+		t.Errorf("Expected seeds 1+4 and 2+3 in the same groups, got %v", in)
+	}
+
+	// in a bracket, the first seed meets the last one
+	tid, _ = DBResetTournament(db, testGuild, "seeding")
+	for i := 0; i < 8; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+		DBSetSeed(db, tid, fmt.Sprintf("ign%d", i), i+1)
+	}
+	err = DBStartTournament(db, tid, Settings{Format: "single", BestOf: 3, Finals: 3})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	pairs := make(map[string]bool)
+	for _, group := range DBGetGroups(db, tid) {
+		pairs[fmt.Sprint(group.Participants)] = true
+	}
+	for _, pair := range []string{"[ign0 ign7]", "[ign3 ign4]", "[ign1 ign6]", "[ign2 ign5]"} {
+		if !pairs[pair] {
+			t.Errorf("Expected first round match %s, got %v", pair, pairs)
+		}
+	}
+}
+
+func TestDrawSeed(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "draw")
+	for i := 0; i < 8; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	settings := Settings{Format: "groups", GroupSize: 4, BestOf: 3, Finals: 3, DrawSeed: 42}
+	err := DBStartTournament(db, tid, settings)
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	if seed := DBGetSettings(db, tid).DrawSeed; seed != 42 {
+		t.Errorf("Expected draw seed 42 to be stored, got %d", seed)
+	}
+
+	// the same seed always gives the same groups
+	var drawn []string
+	for _, group := range DBGetGroups(db, tid) {
+		drawn = append(drawn, fmt.Sprint(group.Participants))
+	}
+	expected := []string{"[ign0 ign1 ign4 ign5]", "[ign2 ign3 ign6 ign7]"}
+	if fmt.Sprint(drawn) != fmt.Sprint(expected) {
+		t.Errorf("Expected groups %v, got %v", expected, drawn)
+	}
+	plan, err := DBDraw(db, tid, DBGetSettings(db, tid))
+	if err != nil {
+		t.Fatalf("Error repeating the draw: %s", err)
+	}
+	for k, group := range DBGetGroups(db, tid) {
+		repeated := append([]string{}, plan.Groups[k].Participants...)
+		sort.Strings(repeated)
+		if fmt.Sprint(repeated) != fmt.Sprint(group.Participants) {
+			t.Errorf("Expected the repeated draw of %s to be %v, got %v", group.Name, group.Participants, repeated)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "preview")
+	for i := 0; i < 16; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	settings := Settings{Format: "groups", GroupSize: 4, BestOf: 3, Finals: 3, DrawSeed: 7}
+	plan, err := DBDraw(db, tid, settings)
+	if err != nil {
+		t.Fatalf("Error previewing tournament: %s", err)
+	}
+	// 4 groups of 4, the first two of each play quarter finals, semi finals and a final
+	if len(plan.Groups) != 11 {
+		t.Errorf("Expected 11 planned groups, got %d", len(plan.Groups))
+	}
+	summary := fmt.Sprintf(i18n[defaultLang]["preview-summary"], 11, 4*6+7)
+	if preview := PlanPreview(plan); !strings.HasPrefix(preview, summary) {
+		t.Errorf("Expected the preview to start with %q, got %q", summary, preview)
+	}
+	final := plan.Groups[len(plan.Groups)-1].Matches[0]
+	if name := plan.SlotName(final.Player1); name != fmt.Sprintf(i18n[defaultLang]["slot-rank-of"], 1, plan.Groups[final.Player1.Group-1].Name) {
+		t.Errorf("Unexpected name of the first finalist: %s", name)
+	}
+
+	// nothing was written
+	if status := DBGetTournamentStatus(db, tid); status != "status-open" {
+		t.Errorf("Expected status-open, got %s", status)
+	}
+	if groups := DBGetGroups(db, tid); len(groups) != 0 {
+		t.Errorf("Expected no groups, got %d", len(groups))
+	}
+
+	// starting with the same seed gives the previewed groups
+	err = DBStartTournament(db, tid, settings)
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	for k, group := range DBGetGroups(db, tid) {
+		previewed := append([]string{}, plan.Groups[k].Participants...)
+		sort.Strings(previewed)
+		if fmt.Sprint(previewed) != fmt.Sprint(group.Participants) {
+			t.Errorf("Expected %s to be %v, got %v", group.Name, previewed, group.Participants)
+		}
+	}
+}
+
+func TestRatings(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "rating")
+	players := []string{"rated-a", "rated-b", "rated-c", "rated-d"}
+	for _, p := range players {
+		DBRegisterParticipant(db, tid, "user-"+p, p)
+	}
+	err := DBStartTournament(db, tid, Settings{Format: "groups", GroupSize: 4, BestOf: 3, Finals: 3})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+
+	// equal ratings change by half of K
+	DBCreateMatch(db, tid, "rated-a", "rated-b", 2, 1)
+	a, b := DBGetRating(db, testGuild, "user-rated-a"), DBGetRating(db, testGuild, "user-rated-b")
+	if a.Rating != InitialRating+RatingK/2 || b.Rating != InitialRating-RatingK/2 || a.Games != 1 {
+		t.Errorf("Expected ratings %v and %v, got %+v and %+v", InitialRating+RatingK/2, InitialRating-RatingK/2, a, b)
+	}
+	// a corrected result replaces the earlier one
+	DBCreateMatch(db, tid, "rated-b", "rated-a", 2, 0)
+	a, b = DBGetRating(db, testGuild, "user-rated-a"), DBGetRating(db, testGuild, "user-rated-b")
+	if a.Rating != InitialRating-RatingK/2 || b.Rating != InitialRating+RatingK/2 || b.Games != 1 {
+		t.Errorf("Expected the corrected ratings, got %+v and %+v", a, b)
+	}
+	history, err := DBGetRatingHistory(db, testGuild, "user-rated-b", 10)
+	if err != nil || len(history) != 1 || history[0].Opponent != "rated-a" || history[0].Tournament != "rating" {
+		t.Errorf("Expected one rated match against rated-a, got %+v %v", history, err)
+	}
+	// the favourite wins less
+	DBCreateMatch(db, tid, "rated-b", "rated-c", 2, 0)
+	if change := DBGetRating(db, testGuild, "user-rated-b").Rating - (InitialRating + RatingK/2); change >= RatingK/2 || change <= 0 {
+		t.Errorf("Expected the favourite to win less than %v, got %v", RatingK/2, change)
+	}
+
+	// ratings survive the reset and a new name, and seed the next draw
+	tid, _ = DBResetTournament(db, testGuild, "rating-2")
+	for _, p := range players {
+		DBRegisterParticipant(db, tid, "user-"+p, p)
+	}
+	DBRegisterParticipant(db, tid, "user-rated-b", "renamed-b")
+	if r := DBGetRating(db, testGuild, "user-rated-b"); r.Games != 2 || r.Player != "rated-b" {
+		t.Errorf("Expected the rating of rated-b to survive the reset, got %+v", r)
+	}
+	// another member with a name used before starts with the initial rating
+	DBRegisterParticipant(db, tid, "newcomer", "rated-b")
+	if r := DBGetRating(db, testGuild, "newcomer"); r.Games != 0 || r.Rating != InitialRating {
+		t.Errorf("Expected the newcomer to be unrated, got %+v", r)
+	}
+	// a rating of an earlier version, whose member is unknown, goes to the member registering with its name
+	db.Exec("INSERT INTO ratings (guild_id, discord_id, ign, rating, games) VALUES (?, ?, 'veteran', 1600, 5)", testGuild, legacyRatingPrefix+"veteran")
+	DBRegisterParticipant(db, tid, "user-veteran", "veteran")
+	if r := DBGetRating(db, testGuild, "user-veteran"); r.Rating != 1600 || r.Games != 5 {
+		t.Errorf("Expected the veteran to claim the legacy rating, got %+v", r)
+	}
+	err = DBStartTournament(db, tid, Settings{Format: "single", BestOf: 3, Finals: 3, RatingSeeds: true})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	seeds := DBGetSeeds(db, tid)
+	if seeds["veteran"] != 1 || seeds["renamed-b"] != 2 || seeds["rated-d"] != 0 || seeds["rated-b"] != 0 || len(seeds) != 4 {
+		t.Errorf("Expected the veteran and renamed-b to be seeded first and rated-d and the newcomer to be unseeded, got %v", seeds)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "history-1")
+	DBRegisterParticipant(db, tid, "user0", "ign0")
+	DBRegisterParticipant(db, tid, "user1", "ign1")
+	err := DBStartTournament(db, tid, Settings{Format: "single", BestOf: 3, Finals: 3})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	DBCreateMatch(db, tid, "ign0", "ign1", 2, 0)
+	advance, _, err := DBCheckGroupComplete(db, DBGetGroups(db, tid)[0].Id)
+	if err != nil || len(advance) != 1 || advance[0].Group.Id != 0 {
+		t.Fatalf("Expected ign0 to win the tournament, got %v %v", advance, err)
+	}
+	DBCloseTournament(db, tid, advance[0].Player)
+
+	// the same players register for the next tournament, which starts empty
+	tid, _ = DBResetTournament(db, testGuild, "history-2")
+	DBRegisterParticipant(db, tid, "user0", "ign0")
+	if groups := DBGetGroups(db, tid); len(groups) != 0 {
+		t.Errorf("Expected no groups in the new tournament, got %d", len(groups))
+	}
+	if status := DBGetTournamentStatus(db, tid); status != "status-open" {
+		t.Errorf("Expected status-open, got %s", status)
+	}
+	tournaments, err := DBGetTournaments(db, testGuild, 2)
+	if err != nil {
+		t.Fatalf("Error reading the history: %s", err)
+	}
+	if len(tournaments) != 2 {
+		t.Fatalf("Expected 2 tournaments, got %d", len(tournaments))
+	}
+	current, past := tournaments[0], tournaments[1]
+	if current.Name != "history-2" || current.Participants != 1 || current.Winner != "" || current.Status != "status-open" {
+		t.Errorf("Unexpected current tournament %+v", current)
+	}
+	if past.Name != "history-1" || past.Participants != 2 || past.Winner != "ign0" || past.Status != "status-finished" {
+		t.Errorf("Unexpected past tournament %+v", past)
+	}
+}
+
+func TestLegacyTournament(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "testing-legacy.sqlite3"))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err)
+	}
+	defer db.Close()
+
+	// a running tournament of a version without tournament ids
+	for _, statement := range []string{
+		"CREATE TABLE options (id INTEGER PRIMARY KEY, key TEXT NOT NULL, value TEXT NOT NULL)",
+		"CREATE TABLE participants (discord_id TEXT PRIMARY KEY NOT NULL, ign TEXT UNIQUE NOT NULL, group_id INTEGER DEFAULT 0)",
+		"CREATE TABLE groups (id INTEGER PRIMARY KEY, name TEXT NOT NULL, complete INTEGER DEFAULT 0)",
+		"CREATE TABLE matches (id INTEGER PRIMARY KEY, group_id INTEGER NOT NULL, bestof INTEGER NOT NULL, player1 TEXT NOT NULL, player2 TEXT NOT NULL, score1 INTEGER DEFAULT 0, score2 INTEGER DEFAULT 0)",
+		"INSERT INTO options (key, value) VALUES ('name', 'legacy'), ('status', 'status-started')",
+		"INSERT INTO participants (discord_id, ign, group_id) VALUES ('user0', 'ign0', 1), ('user1', 'ign1', 1)",
+		"INSERT INTO groups (name) VALUES ('Gruppe A'), ('Finale')",
+		"INSERT INTO matches (group_id, bestof, player1, player2) VALUES (1, 3, 'ign0', 'ign1'), (2, 3, '!G1', '!G1.2')",
+		"CREATE TABLE ratings (ign TEXT PRIMARY KEY NOT NULL, rating REAL NOT NULL, games INTEGER DEFAULT 0)",
+		"INSERT INTO ratings (ign, rating, games) VALUES ('ign0', 1516, 1)",
+	} {
+		if _, err = db.Exec(statement); err != nil {
+			t.Fatalf("Error creating legacy database: %s", err)
+		}
+	}
+
+	err = DBMigrate(db)
+	if err != nil {
+		t.Fatalf("Error upgrading database: %s", err)
+	}
+	if version, _ := DBSchemaVersion(db); version != SchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", SchemaVersion(), version)
+	}
+	tid := DBTournamentId(db, "")
+	if name := DBGetTournamentName(db, tid); name != "legacy" {
+		t.Errorf("Expected the legacy tournament to be current, got %s", name)
+	}
+	if groups := DBGetGroups(db, tid); len(groups) != 1 || len(groups[0].Participants) != 2 {
+		t.Errorf("Expected the legacy group with 2 participants, got %v", groups)
+	}
+	// upgrading twice changes nothing
+	err = DBMigrate(db)
+	if err != nil {
+		t.Fatalf("Error upgrading database again: %s", err)
+	}
+	if participants := DBGetParticipants(db, tid, 0); len(participants) != 2 {
+		t.Errorf("Expected 2 participants, got %v", participants)
+	}
+
+	// the bot served a single guild, which gets the tournament and the ratings
+	err = DBAdoptGuild(db, "legacy-guild")
+	if err != nil {
+		t.Fatalf("Error adopting the legacy tournament: %s", err)
+	}
+	if id := DBTournamentId(db, "legacy-guild"); id != tid {
+		t.Errorf("Expected tournament %d in the guild, got %d", tid, id)
+	}
+	if r := DBGetRating(db, "legacy-guild", "user0"); r.Rating != 1516 || r.Games != 1 || r.Player != "ign0" {
+		t.Errorf("Expected the legacy rating of ign0, got %+v", r)
+	}
+
+	// the final still waits for the players of the group, who advance into it
+	if final := DBGetMatches(db, tid, 2); len(final) != 1 || final[0].Player1 != "!G1" || final[0].Player2 != "!G1.2" {
+		t.Fatalf("Expected the final to wait for both players of the group, got %v", final)
+	}
+	err = DBCreateMatch(db, tid, "ign1", "ign0", 2, 1)
+	if err != nil {
+		t.Fatalf("Error setting score: %s", err)
+	}
+	_, _, err = DBCheckGroupComplete(db, 1)
+	if err != nil {
+		t.Fatalf("Error completing the legacy group: %s", err)
+	}
+	if final := DBGetMatches(db, tid, 2); len(final) != 1 || final[0].Player1 != "ign1" || final[0].Player2 != "ign0" {
+		t.Errorf("Expected ign1 and ign0 in the final, got %v", final)
+	}
+}
+
+func TestParticipantIds(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "ids")
+	for i := 0; i < 4; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	// registering again renames the participant
+	err := DBRegisterParticipant(db, tid, "user0", "renamed")
+	if err != nil {
+		t.Fatalf("Error renaming participant: %s", err)
+	}
+	if participants := DBGetParticipants(db, tid, 0); len(participants) != 4 {
+		t.Errorf("Expected 4 participants, got %v", participants)
+	}
+	err = DBStartTournament(db, tid, Settings{Format: "single", BestOf: 3, Finals: 3})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+
+	// matches follow the participant, not the name
+	var renamed Match
+	for _, m := range DBGetMatches(db, tid, 0) {
+		if m.Player1 == "renamed" || m.Player2 == "renamed" {
+			renamed = m
+		}
+	}
+	if renamed.Id == 0 {
+		t.Fatalf("Expected a match of the renamed player")
+	}
+	opponent := renamed.Player1
+	if opponent == "renamed" {
+		opponent = renamed.Player2
+	}
+	db.Exec("UPDATE participants SET ign = 'ign0' WHERE tournament_id = ? AND discord_id = 'user0'", tid)
+	m, err := DBFindMatch(db, tid, "ign0", opponent)
+	if err != nil || m.Id != renamed.Id {
+		t.Errorf("Expected match %d under the new name, got %+v %v", renamed.Id, m, err)
+	}
+
+	// matches only reference existing participants and groups
+	_, err = db.Exec("INSERT INTO matches (group_id, bestof, player1_id) VALUES (?, 3, 999999)", renamed.GroupId)
+	if err == nil {
+		t.Errorf("Expected a match of an unknown participant to fail")
+	}
+	_, err = db.Exec("DELETE FROM groups WHERE id = ?", renamed.GroupId)
+	if err == nil {
+		t.Errorf("Expected deleting a group with matches to fail")
+	}
+}
+
+func TestGuilds(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	// both guilds run a tournament with the same players
+	var tournaments []int
+	for _, guild := range []string{"guild-a", "guild-b"} {
+		id, err := DBResetTournament(db, guild, "cup")
+		if err != nil {
+			t.Fatalf("Error creating tournament in %s: %s", guild, err)
+		}
+		DBRegisterParticipant(db, id, "user0", "ign0")
+		DBRegisterParticipant(db, id, "user1", "ign1")
+		err = DBStartTournament(db, id, Settings{Format: "single", BestOf: 3, Finals: 3})
+		if err != nil {
+			t.Fatalf("Error starting tournament in %s: %s", guild, err)
+		}
+		tournaments = append(tournaments, id)
+	}
+	if id := DBTournamentId(db, "guild-a"); id != tournaments[0] {
+		t.Errorf("Expected tournament %d in guild-a, got %d", tournaments[0], id)
+	}
+	if guild := DBGetTournamentGuild(db, tournaments[1]); guild != "guild-b" {
+		t.Errorf("Expected tournament %d in guild-b, got %s", tournaments[1], guild)
+	}
+	if active := DBActiveTournaments(db, "guild-a"); len(active) != 1 || active[0] != tournaments[0] {
+		t.Errorf("Expected only tournament %d to be active in guild-a, got %v", tournaments[0], active)
+	}
+
+	// ratings only change in the guild of the match
+	err := DBCreateMatch(db, tournaments[0], "ign0", "ign1", 2, 0)
+	if err != nil {
+		t.Fatalf("Error setting score: %s", err)
+	}
+	if r := DBGetRating(db, "guild-a", "user0"); r.Games != 1 || r.Rating <= InitialRating {
+		t.Errorf("Expected ign0 to gain rating in guild-a, got %+v", r)
+	}
+	if r := DBGetRating(db, "guild-b", "user0"); r.Games != 0 || r.Rating != InitialRating {
+		t.Errorf("Expected ign0 to be unrated in guild-b, got %+v", r)
+	}
+
+	// guilds keep their own settings
+	err = DBSetGuild(db, GuildSettings{GuildId: "guild-a", Lang: "en", ParticipantRoleId: "role-a"})
+	if err != nil {
+		t.Fatalf("Error saving guild settings: %s", err)
+	}
+	if guild := DBGetGuild(db, "guild-a"); guild.Lang != "en" || guild.ParticipantRoleId != "role-a" {
+		t.Errorf("Expected the settings of guild-a, got %+v", guild)
+	}
+	if guild := DBGetGuild(db, "guild-b"); guild.Lang != "" || guild.ParticipantRoleId != "" {
+		t.Errorf("Expected no settings for guild-b, got %+v", guild)
+	}
+
+	// and their own roles for commands
+	DBSetGuild(db, GuildSettings{GuildId: "guild-a", Lang: "en", ParticipantRoleId: "role-a", OrganizerRoleId: "organizers-a"})
+	if guild := DBGetGuild(db, "guild-a"); guild.OrganizerRoleId != "organizers-a" || guild.ParticipantRoleId != "role-a" {
+		t.Errorf("Expected the organizer role of guild-a, got %+v", guild)
+	}
+	DBSetCommandRole(db, "guild-a", "turn-start", "starters")
+	DBSetCommandRole(db, "guild-a", "turn-start", "hosts")
+	if roles := DBGetCommandRoles(db, "guild-a"); len(roles) != 1 || roles["turn-start"] != "hosts" {
+		t.Errorf("Expected hosts to start tournaments in guild-a, got %v", roles)
+	}
+	if roles := DBGetCommandRoles(db, "guild-b"); len(roles) != 0 {
+		t.Errorf("Expected no command roles in guild-b, got %v", roles)
+	}
+}
+
+func TestConcurrentTournaments(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	// two brackets with the same players run side by side
+	chess, _ := DBResetTournament(db, testGuild, "chess")
+	golf, _ := DBResetTournament(db, testGuild, "golf")
+	for _, id := range []int{chess, golf} {
+		for k := 0; k < 4; k++ {
+			DBRegisterParticipant(db, id, fmt.Sprintf("user%d", k), fmt.Sprintf("ign%d", k))
+		}
+	}
+	DBRegisterParticipant(db, golf, "user4", "ign4")
+	err := DBStartTournament(db, chess, Settings{Format: "single", BestOf: 3, Finals: 3, DrawSeed: 1})
+	if err != nil {
+		t.Fatalf("Error starting chess: %s", err)
+	}
+	if status := DBGetTournamentStatus(db, golf); status != "status-open" {
+		t.Errorf("Expected golf to stay open, got %s", status)
+	}
+	err = DBStartTournament(db, golf, Settings{Format: "single", BestOf: 3, Finals: 3, DrawSeed: 1})
+	if err != nil {
+		t.Fatalf("Error starting golf: %s", err)
+	}
+	if n := len(DBGetParticipants(db, chess, 0)); n != 4 {
+		t.Errorf("Expected 4 chess players, got %d", n)
+	}
+	if n := len(DBGetParticipants(db, golf, 0)); n != 5 {
+		t.Errorf("Expected 5 golf players, got %d", n)
+	}
+
+	// a result only counts in the selected tournament
+	first := DBGetGroups(db, chess)[0]
+	p1, p2 := first.Participants[0], first.Participants[1]
+	group, _ := DBGetGroupAndBestOf(db, chess, p1, p2)
+	if group.Id != first.Id {
+		t.Fatalf("Expected %s vs %s in chess group %d, got %d", p1, p2, first.Id, group.Id)
+	}
+	err = DBCreateMatch(db, chess, p1, p2, 2, 0)
+	if err != nil {
+		t.Fatalf("Error setting score: %s", err)
+	}
+	games, err := DBGetAllGames(db, golf)
+	if err != nil {
+		t.Fatalf("Error reading games: %s", err)
+	}
+	for _, g := range games {
+		for _, m := range g.Matches {
+			if m.Score1 > 0 || m.Score2 > 0 {
+				t.Errorf("Expected no results in golf, got %s vs %s %d-%d", m.Player1, m.Player2, m.Score1, m.Score2)
+			}
+		}
+	}
+	advance, _, err := DBCheckGroupComplete(db, first.Id)
+	if err != nil || len(advance) == 0 {
+		t.Fatalf("Expected %s to advance, got %v %v", p1, advance, err)
+	}
+	if DBGroupTournament(db, advance[0].Group.Id) != chess {
+		t.Errorf("Expected %s to advance within chess, got group %d", p1, advance[0].Group.Id)
+	}
+
+	// channels remember their tournament
+	err = DBSetChannelTournament(db, "chess-channel", chess)
+	if err != nil {
+		t.Fatalf("Error setting channel tournament: %s", err)
+	}
+	DBSetChannelTournament(db, "golf-channel", chess)
+	DBSetChannelTournament(db, "golf-channel", golf)
+	if id := DBGetChannelTournament(db, "chess-channel"); id != chess {
+		t.Errorf("Expected chess in the chess channel, got %d", id)
+	}
+	if id := DBGetChannelTournament(db, "golf-channel"); id != golf {
+		t.Errorf("Expected golf in the golf channel, got %d", id)
+	}
+	if id := DBGetChannelTournament(db, "other-channel"); id != 0 {
+		t.Errorf("Expected no default in other channels, got %d", id)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	for k, m := range migrations {
+		if m.Version != k+1 {
+			t.Errorf("Expected migration %d to have version %d, got %d", k, k+1, m.Version)
+		}
+	}
+
+	db, err := OpenDB(filepath.Join(t.TempDir(), "testing-migrations.sqlite3"))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err)
+	}
+	defer db.Close()
+
+	// a new state file gets all tables before the first tournament
+	err = DBMigrate(db)
+	if err != nil {
+		t.Fatalf("Error migrating new database: %s", err)
+	}
+	for _, table := range []string{"options", "participants", "groups", "matches", "ratings", "rating_history", "tournaments", "channel_tournaments", "guilds", "reports", "command_roles"} {
+		var count int
+		db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+		if count != 1 {
+			t.Errorf("Expected table %s to exist", table)
+		}
+	}
+	if tournaments, err := DBGetTournaments(db, testGuild, 10); err != nil || len(tournaments) != 0 {
+		t.Errorf("Expected no tournaments, got %v %v", tournaments, err)
+	}
+
+	// versions before schema_version upgraded on the fly, their changes are already applied
+	id, err := DBResetTournament(db, testGuild, "unversioned")
+	if err != nil {
+		t.Fatalf("Error creating tournament: %s", err)
+	}
+	DBRegisterParticipant(db, id, "user0", "ign0")
+	db.Exec("DROP TABLE schema_version")
+	err = DBMigrate(db)
+	if err != nil {
+		t.Fatalf("Error migrating unversioned database: %s", err)
+	}
+	if participants := DBGetParticipants(db, id, 0); len(participants) != 1 {
+		t.Errorf("Expected the participant to be kept, got %v", participants)
+	}
+	var applied int
+	db.QueryRow("SELECT count(*) FROM schema_version").Scan(&applied)
+	if applied != len(migrations) {
+		t.Errorf("Expected %d applied migrations, got %d", len(migrations), applied)
+	}
+
+	// a database of a newer version is not touched
+	db.Exec("INSERT INTO schema_version (version, description) VALUES (?, 'future')", SchemaVersion()+1)
+	if err = DBMigrate(db); err == nil {
+		t.Errorf("Expected a newer schema version to fail")
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	// a trigger makes a statement fail half-way through an operation
+	fail := func(name, event string) {
+		_, err := db.Exec(fmt.Sprintf("CREATE TRIGGER %s BEFORE %s BEGIN SELECT RAISE(ABORT, 'injected failure'); END", name, event))
+		if err != nil {
+			t.Fatalf("Error creating trigger %s: %s", name, err)
+		}
+	}
+	defer db.Exec("DROP TRIGGER IF EXISTS fail_start")
+	defer db.Exec("DROP TRIGGER IF EXISTS fail_rating")
+	defer db.Exec("DROP TRIGGER IF EXISTS fail_advance")
+
+	tid, _ := DBResetTournament(db, testGuild, "transactions")
+	for i := 0; i < 4; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	settings := Settings{Format: "single", BestOf: 3, Finals: 3, DrawSeed: 1}
+
+	// the groups are written before the matches
+	fail("fail_start", "INSERT ON matches")
+	if err := DBStartTournament(db, tid, settings); err == nil {
+		t.Fatalf("Expected the start to fail")
+	}
+	var groups, options int
+	db.QueryRow("SELECT count(*) FROM groups WHERE tournament_id = ?", tid).Scan(&groups)
+	db.QueryRow("SELECT count(*) FROM options WHERE tournament_id = ?", tid).Scan(&options)
+	if groups != 0 || options != 2 || DBGetTournamentStatus(db, tid) != "status-open" {
+		t.Errorf("Expected the tournament to be unchanged, got %d groups, %d options and status %s", groups, options, DBGetTournamentStatus(db, tid))
+	}
+	db.Exec("DROP TRIGGER fail_start")
+	if err := DBStartTournament(db, tid, settings); err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+
+	// the score is written before the ratings
+	var first Group
+	for _, g := range DBGetGroups(db, tid) {
+		if len(g.Participants) == 2 {
+			first = g
+			break
+		}
+	}
+	p1, p2 := first.Participants[0], first.Participants[1]
+	fail("fail_rating", "INSERT ON rating_history")
+	if err := DBCreateMatch(db, tid, p1, p2, 2, 0); err == nil {
+		t.Fatalf("Expected the result to fail")
+	}
+	if m, _ := DBFindMatch(db, tid, p1, p2); m.Score1 != 0 || m.Score2 != 0 {
+		t.Errorf("Expected no score, got %+v", m)
+	}
+	db.Exec("DROP TRIGGER fail_rating")
+	if err := DBCreateMatch(db, tid, p1, p2, 2, 0); err != nil {
+		t.Fatalf("Error setting score: %s", err)
+	}
+
+	// the group is marked complete before the winner advances
+	before := DBGetMatches(db, tid, 0)
+	fail("fail_advance", "UPDATE OF player1_id, player2_id ON matches")
+	if _, _, err := DBCheckGroupComplete(db, first.Id); err == nil {
+		t.Fatalf("Expected completing the group to fail")
+	}
+	var complete int
+	db.QueryRow("SELECT complete FROM groups WHERE id = ?", first.Id).Scan(&complete)
+	if complete != 0 {
+		t.Errorf("Expected %s to stay open", first.Name)
+	}
+	if after := DBGetMatches(db, tid, 0); fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("Expected the matches to be unchanged, got %v instead of %v", after, before)
+	}
+	db.Exec("DROP TRIGGER fail_advance")
+	advance, _, err := DBCheckGroupComplete(db, first.Id)
+	if err != nil || len(advance) != 1 || advance[0].Player != p1 {
+		t.Errorf("Expected %s to advance, got %v %v", p1, advance, err)
+	}
+}
+
+func TestConcurrentResults(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "concurrent")
+	// players register at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i)); err != nil {
+				t.Errorf("Error registering participant: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if participants := DBGetParticipants(db, tid, 0); len(participants) != 8 {
+		t.Fatalf("Expected 8 participants, got %v", participants)
+	}
+
+	// the tournament starts once
+	var started atomic.Int32
+	for k := 0; k < 4; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if DBStartTournament(db, tid, Settings{Format: "single", BestOf: 3, Finals: 3}) == nil {
+				started.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	groups := DBGetGroups(db, tid)
+	if started.Load() != 1 || len(groups) != 4 {
+		t.Fatalf("Expected one start with 4 matches, got %d starts and %d groups", started.Load(), len(groups))
+	}
+	if err := DBRegisterParticipant(db, tid, "late", "late"); err == nil {
+		t.Errorf("Expected registering after the start to fail")
+	}
+
+	// both players report the result at the same time, the group is completed once
+	first := groups[0]
+	p1, p2 := first.Participants[0], first.Participants[1]
+	var completed atomic.Int32
+	for k := 0; k < 8; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := DBCreateMatch(db, tid, p1, p2, 2, 0); err != nil {
+				t.Errorf("Error setting score: %s", err)
+			}
+			advance, _, err := DBCheckGroupComplete(db, first.Id)
+			if err != nil {
+				t.Errorf("Error completing %s: %s", first.Name, err)
+			}
+			if len(advance) > 0 {
+				completed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if completed.Load() != 1 {
+		t.Errorf("Expected the group to be completed once, got %d", completed.Load())
+	}
+	slots := 0
+	for _, m := range DBGetMatches(db, tid, 0) {
+		if m.Player1 == p1 || m.Player2 == p1 {
+			slots++
+		}
+	}
+	if slots != 2 {
+		t.Errorf("Expected %s in the first match and the next round, got %d matches", p1, slots)
+	}
+	var rated int
+	db.QueryRow("SELECT count(*) FROM rating_history WHERE match_id = (SELECT id FROM matches WHERE group_id = ?)", first.Id).Scan(&rated)
+	if rated != 2 {
+		t.Errorf("Expected the match to be rated once for both players, got %d changes", rated)
+	}
+}
+
+func TestReports(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+
+	tid, _ := DBResetTournament(db, testGuild, "reports")
+	for i := 0; i < 4; i++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", i), fmt.Sprintf("ign%d", i))
+	}
+	err := DBStartTournament(db, tid, Settings{Format: "single", BestOf: 3, Finals: 3, DrawSeed: 1})
+	if err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	first := DBGetGroups(db, tid)[0]
+	p1, p2 := first.Participants[0], first.Participants[1]
+	score := func() (int, int) {
+		m := DBGetMatches(db, tid, first.Id)[0]
+		return m.Score1, m.Score2
+	}
+
+	// players of other matches can not report
+	if _, err := DBReportResult(db, tid, "stranger", p1, p2, 2, 0); err == nil {
+		t.Errorf("Expected a report by an unknown member to fail")
+	}
+	for _, p := range DBGetGroups(db, tid)[1].Participants {
+		if _, err := DBReportResult(db, tid, DBGetDiscordId(db, tid, p), p1, p2, 2, 0); err == nil {
+			t.Errorf("Expected a report by %s of another match to fail", p)
+		}
+	}
+
+	// the opponent of the reporter confirms, the reported scores follow the order of the match
+	reporter := DBGetDiscordId(db, tid, p2)
+	report, err := DBReportResult(db, tid, reporter, p2, p1, 1, 2)
+	if err != nil {
+		t.Fatalf("Error reporting result: %s", err)
+	}
+	if report.Player1 != p1 || report.Score1 != 2 || report.Score2 != 1 || report.Status != "pending" {
+		t.Errorf("Expected a pending report %s vs %s 2-1, got %v", p1, p2, report)
+	}
+	if opponents := DBReportOpponents(db, report); len(opponents) != 1 || opponents[0] != DBGetDiscordId(db, tid, p1) {
+		t.Errorf("Expected %s to decide the report, got %v", p1, opponents)
+	}
+	if s1, s2 := score(); s1 != 0 || s2 != 0 {
+		t.Errorf("Expected no score before the confirmation, got %d-%d", s1, s2)
+	}
+
+	// a disputed report does not change the score, and can not be confirmed later
+	disputed, err := DBDisputeReport(db, report.Id)
+	if err != nil || disputed.Status != "disputed" {
+		t.Errorf("Error disputing report: %v %s", disputed, err)
+	}
+	if _, err := DBConfirmReport(db, report.Id); err == nil {
+		t.Errorf("Expected confirming a disputed report to fail")
+	}
+
+	// a new report replaces the pending one
+	replaced, _ := DBReportResult(db, tid, reporter, p1, p2, 1, 2)
+	report, _ = DBReportResult(db, tid, reporter, p1, p2, 2, 0)
+	if replaced, _ = DBGetReport(db, replaced.Id); replaced.Status != "replaced" {
+		t.Errorf("Expected the first report to be replaced, got %s", replaced.Status)
+	}
+	confirmed, err := DBConfirmReport(db, report.Id)
+	if err != nil || confirmed.Status != "confirmed" {
+		t.Errorf("Error confirming report: %v %s", confirmed, err)
+	}
+	if s1, s2 := score(); s1 != 2 || s2 != 0 {
+		t.Errorf("Expected the confirmed score 2-0, got %d-%d", s1, s2)
+	}
+
+	// reports of completed groups are closed
+	DBCheckGroupComplete(db, first.Id)
+	if _, err := DBReportResult(db, tid, reporter, p1, p2, 2, 1); err == nil {
+		t.Errorf("Expected reporting a completed match to fail")
+	}
+	if _, err := DBDisputeReport(db, report.Id); err == nil {
+		t.Errorf("Expected deciding a confirmed report to fail")
+	}
+}
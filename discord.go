@@ -2,33 +2,64 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 type TurnvaterBot struct {
-	Token             string
-	AppId             string
-	GuildId           string
-	Participants      []string
+	Token    string
+	AppId    string
+	GuildIds []string
+	// Global registers the commands once for all guilds, instead of in each of GuildIds
+	Global bool
+	// ParticipantRoleId is the role given to registered participants in guilds without their own
 	ParticipantRoleId string
-	Groups            []string
-	Restart           bool
+	// OrganizerRoleId is the role of the tournament organizers in guilds without their own
+	OrganizerRoleId string
+	// RemoveCommands removes the registered commands when the bot exits
+	RemoveCommands bool
 
+	// Session is the connection to discord for the lifetime of the bot
 	Session *discordgo.Session
+	// refresh asks Run to register the commands of the pending guilds again, e.g. in the new language of a guild
+	refresh chan struct{}
+	pending struct {
+		sync.Mutex
+		guilds map[string]bool
+	}
 }
 
 var commands = map[string]func(*discordgo.Session, *discordgo.InteractionCreate){
 	"turn-reset":       TurnResetHandler,
+	"turn-channel":     TurnChannelHandler,
+	"turn-guild":       TurnGuildHandler,
 	"turn-register":    TurnRegisterHandler,
+	"turn-seed":        TurnSeedHandler,
 	"turn-status":      TurnStatusHandler,
+	"turn-rating":      TurnRatingHandler,
+	"turn-history":     TurnHistoryHandler,
 	"turn-start":       TurnStartHandler,
+	"turn-preview":     TurnPreviewHandler,
+	"turn-draw":        TurnDrawHandler,
 	"turn-result":      TurnResultHandler,
 	"turn-games":       TurnGamesHandler,
 	"turn-table":       TurnTableHandler,
 	"turn-close-group": TurnCloseGroupHandler,
 }
 
+// buttons handle the components of messages by the prefix of their custom id, which is followed by the id of a report
+var buttons = map[string]func(*discordgo.Session, *discordgo.InteractionCreate, int){
+	"report-confirm": ReportConfirmHandler,
+	"report-dispute": ReportDisputeHandler,
+}
+
 func GenChoices(choices []string) []*discordgo.ApplicationCommandOptionChoice {
 	var result []*discordgo.ApplicationCommandOptionChoice
 	for _, choice := range choices {
@@ -40,56 +71,155 @@ func GenChoices(choices []string) []*discordgo.ApplicationCommandOptionChoice {
 	return result
 }
 
-func NewBot(token string, appId string, guildId string, participants []string, participantRoleId string, groups []string) (TurnvaterBot, error) {
-	bot := TurnvaterBot{
+// GenLocalizedChoices shows the translation of prefix+choice in lang, but sends the choice itself
+func GenLocalizedChoices(choices []string, prefix, lang string) []*discordgo.ApplicationCommandOptionChoice {
+	var result []*discordgo.ApplicationCommandOptionChoice
+	for _, choice := range choices {
+		result = append(result, &discordgo.ApplicationCommandOptionChoice{
+			Name:  i18n[lang][prefix+choice],
+			Value: choice,
+		})
+	}
+	return result
+}
+
+// NewBot creates a bot with its settings, see Open to connect it to discord
+func NewBot(token string, appId string, guildIds []string, global bool, participantRoleId, organizerRoleId string) *TurnvaterBot {
+	bot := &TurnvaterBot{
 		Token:             token,
 		AppId:             appId,
-		GuildId:           guildId,
-		Participants:      participants,
+		GuildIds:          guildIds,
+		Global:            global,
 		ParticipantRoleId: participantRoleId,
-		Restart:           false,
-		Groups:            groups,
+		OrganizerRoleId:   organizerRoleId,
+		refresh:           make(chan struct{}, 1),
 	}
-
-	err := bot.ReRegisterCommands()
-
-	return bot, err
+	bot.pending.guilds = make(map[string]bool)
+	return bot
 }
 
-func (bot *TurnvaterBot) ReRegisterCommands() error {
+// Open connects to discord and registers the commands. Interactions arrive once the session is open,
+// so the bot has to be complete and assigned to turnvater before.
+func (bot *TurnvaterBot) Open() error {
 	dg, err := discordgo.New("Bot " + bot.Token)
 	if err != nil {
 		return fmt.Errorf("error creating discord session: %w", err)
 	}
-	//defer dg.Close()
-
 	dg.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
 		fmt.Printf("Logged in as: %v#%v\n", s.State.User.Username, s.State.User.Discriminator)
 	})
-	dg.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		if i.Type == discordgo.InteractionApplicationCommand {
-			if handler, ok := commands[i.ApplicationCommandData().Name]; ok {
-				handler(s, i)
-			} else {
-				fmt.Println("Unknown command", i.ApplicationCommandData().Name)
-			}
-		}
-	})
+	dg.AddHandler(HandleInteraction)
 
 	// wait until ready
 	err = dg.Open()
 	if err != nil {
 		return fmt.Errorf("error opening discord connection: %w", err)
 	}
+	bot.Session = dg
 
-	// Register slash commands and their handlers
-	// /turn-reset
+	err = bot.RegisterCommands()
+	if err != nil {
+		dg.Close()
+		return err
+	}
+	return nil
+}
 
-	_, err = dg.ApplicationCommandCreate(bot.AppId, bot.GuildId, &discordgo.ApplicationCommand{
-		Name:                     "turn-reset",
-		Description:              i18n[lang]["turn-reset"],
-		DefaultMemberPermissions: &permAdmin,
-		DMPermission:             &allow,
+// commandTargets are the guilds to register the commands in, the empty guild registers global commands
+func (bot *TurnvaterBot) commandTargets() []string {
+	if bot.Global {
+		return []string{""}
+	}
+	return bot.GuildIds
+}
+
+// RegisterCommands registers the slash commands in the language of each guild
+func (bot *TurnvaterBot) RegisterCommands() error {
+	for _, guildId := range bot.commandTargets() {
+		err := bot.registerCommands(bot.Session, guildId)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Commands registered.")
+
+	return nil
+}
+
+// Refresh asks Run to register the commands of a guild again. Refreshes requested while one is pending are merged into it.
+// Global commands are described in the default language, they are not refreshed for a single guild.
+func (bot *TurnvaterBot) Refresh(guildId string) {
+	if bot.refresh == nil || bot.Global {
+		return
+	}
+	served := false
+	for _, id := range bot.GuildIds {
+		served = served || id == guildId
+	}
+	if !served {
+		return
+	}
+	bot.pending.Lock()
+	bot.pending.guilds[guildId] = true
+	bot.pending.Unlock()
+	select {
+	case bot.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// HandleInteraction dispatches an interaction to its handler. discordgo calls it on a goroutine of its own for each interaction.
+func HandleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	handling.RLock()
+	defer handling.RUnlock()
+	if i.Type == discordgo.InteractionApplicationCommand {
+		if handler, ok := commands[i.ApplicationCommandData().Name]; ok {
+			handler(s, i)
+		} else {
+			fmt.Println("Unknown command", i.ApplicationCommandData().Name)
+		}
+	} else if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+		Autocomplete(s, i)
+	} else if i.Type == discordgo.InteractionMessageComponent {
+		name, id, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+		reportId, err := strconv.Atoi(id)
+		if handler, ok := buttons[name]; ok && err == nil {
+			handler(s, i, reportId)
+		} else {
+			fmt.Println("Unknown button", i.MessageComponentData().CustomID)
+		}
+	}
+}
+
+// registerCommands replaces the slash commands of a guild at once. An empty guild registers global commands.
+func (bot *TurnvaterBot) registerCommands(dg *discordgo.Session, guildId string) error {
+	_, err := dg.ApplicationCommandBulkOverwrite(bot.AppId, guildId, CommandDefinitions(guildId))
+	if err != nil {
+		return fmt.Errorf("error registering commands: %w", err)
+	}
+	return nil
+}
+
+// CommandDefinitions describes the slash commands in the language of a guild. Only /turn-guild is hidden from members
+// without admin permissions, the handlers check the roles chosen for the roleCommands themselves.
+func CommandDefinitions(guildId string) []*discordgo.ApplicationCommand {
+	lang := GuildLang(guildId)
+	var definitions []*discordgo.ApplicationCommand
+	// all commands about a tournament apply to the default tournament of the channel, unless one is selected
+	tournamentOption := &discordgo.ApplicationCommandOption{
+		Type:         discordgo.ApplicationCommandOptionInteger,
+		Name:         "tournament",
+		Description:  i18n[lang]["opt-tournament"],
+		Required:     false,
+		Autocomplete: true,
+	}
+
+	// /turn-reset
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:         "turn-reset",
+		Description:  i18n[lang]["turn-reset"],
+		DMPermission: &allow,
 		Options: []*discordgo.ApplicationCommandOption{
 			{
 				Type:        discordgo.ApplicationCommandOptionString,
@@ -99,12 +229,67 @@ func (bot *TurnvaterBot) ReRegisterCommands() error {
 			},
 		},
 	})
-	if err != nil {
-		return fmt.Errorf("error creating command: %w", err)
-	}
+
+	// /turn-channel
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:         "turn-channel",
+		Description:  i18n[lang]["turn-channel"],
+		DMPermission: &deny,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionInteger,
+				Name:         "tournament",
+				Description:  i18n[lang]["opt-tournament"],
+				Required:     true,
+				Autocomplete: true,
+			},
+		},
+	})
+
+	// /turn-guild
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:                     "turn-guild",
+		Description:              i18n[lang]["turn-guild"],
+		DefaultMemberPermissions: &permAdmin,
+		DMPermission:             &deny,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "lang",
+				Description: i18n[lang]["opt-lang"],
+				Required:    false,
+				Choices:     GenChoices([]string{"de", "en"}),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionRole,
+				Name:        "participant-role",
+				Description: i18n[lang]["opt-participant-role"],
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionRole,
+				Name:        "organizer-role",
+				Description: i18n[lang]["opt-organizer-role"],
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "command",
+				Description: i18n[lang]["opt-command"],
+				Required:    false,
+				Choices:     GenChoices(roleCommands),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionRole,
+				Name:        "command-role",
+				Description: i18n[lang]["opt-command-role"],
+				Required:    false,
+			},
+		},
+	})
 
 	// /turn-register
-	_, err = dg.ApplicationCommandCreate(bot.AppId, bot.GuildId, &discordgo.ApplicationCommand{
+	definitions = append(definitions, &discordgo.ApplicationCommand{
 		Name:         "turn-register",
 		Description:  i18n[lang]["turn-register"],
 		DMPermission: &deny,
@@ -115,68 +300,187 @@ func (bot *TurnvaterBot) ReRegisterCommands() error {
 				Description: i18n[lang]["opt-ign"],
 				Required:    true,
 			},
+			tournamentOption,
+		},
+	})
+
+	// /turn-seed
+	seedMin := 0.0
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:         "turn-seed",
+		Description:  i18n[lang]["turn-seed"],
+		DMPermission: &allow,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "player",
+				Description:  i18n[lang]["opt-player"],
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "seed",
+				Description: i18n[lang]["opt-seed"],
+				Required:    true,
+				MinValue:    &seedMin,
+			},
+			tournamentOption,
 		},
 	})
-	if err != nil {
-		return fmt.Errorf("error creating command: %w", err)
-	}
 
 	// /turn-status
-	_, err = dg.ApplicationCommandCreate(bot.AppId, bot.GuildId, &discordgo.ApplicationCommand{
+	definitions = append(definitions, &discordgo.ApplicationCommand{
 		Name:         "turn-status",
 		Description:  i18n[lang]["turn-status"],
 		DMPermission: &allow,
+		Options:      []*discordgo.ApplicationCommandOption{tournamentOption},
 	})
-	if err != nil {
-		return fmt.Errorf("error creating command: %w", err)
-	}
 
-	// /turn-start
-	_, err = dg.ApplicationCommandCreate(bot.AppId, bot.GuildId, &discordgo.ApplicationCommand{
-		Name:                     "turn-start",
-		Description:              i18n[lang]["turn-start"],
-		DMPermission:             &allow,
-		DefaultMemberPermissions: &permAdmin,
+	// /turn-rating
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:         "turn-rating",
+		Description:  i18n[lang]["turn-rating"],
+		DMPermission: &allow,
 		Options: []*discordgo.ApplicationCommandOption{
 			{
-				Type:        discordgo.ApplicationCommandOptionInteger,
-				Name:        "groupsize",
-				Description: i18n[lang]["opt-groupsize"],
-				Required:    true,
-				Choices:     GenChoices([]string{"2", "3", "4", "5", "6"}),
-			},
-			{
-				Type:        discordgo.ApplicationCommandOptionInteger,
-				Name:        "bestof",
-				Description: i18n[lang]["opt-bestof"],
-				Required:    true,
-				Choices:     GenChoices([]string{"1", "3", "5", "7", "9"}),
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "player",
+				Description: i18n[lang]["opt-rating-player"],
+				Required:    false,
 			},
+		},
+	})
+
+	// /turn-history
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:         "turn-history",
+		Description:  i18n[lang]["turn-history"],
+		DMPermission: &allow,
+	})
+
+	// /turn-start and /turn-preview take the same settings
+	startOptions := []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "bestof",
+			Description: i18n[lang]["opt-bestof"],
+			Required:    true,
+			Choices:     GenChoices([]string{"1", "3", "5", "7", "9"}),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "finals-bestof",
+			Description: i18n[lang]["opt-finals-bestof"],
+			Required:    true,
+			Choices:     GenChoices([]string{"1", "3", "5", "7", "9"}),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "groupsize",
+			Description: i18n[lang]["opt-groupsize"],
+			Required:    false,
+			Choices:     GenChoices([]string{"2", "3", "4", "5", "6"}),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "format",
+			Description: i18n[lang]["opt-format"],
+			Required:    false,
+			Choices:     GenLocalizedChoices(FormatNames(), "format-", lang),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "rounds",
+			Description: i18n[lang]["opt-rounds"],
+			Required:    false,
+			Choices:     GenChoices([]string{"3", "4", "5", "6", "7", "8", "9"}),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "fill",
+			Description: i18n[lang]["opt-fill"],
+			Required:    false,
+			Choices:     GenLocalizedChoices([]string{"reduce", "byes", "wildcards"}, "fill-", lang),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "advance",
+			Description: i18n[lang]["opt-advance"],
+			Required:    false,
+			Choices:     GenChoices([]string{"1", "2", "3", "4"}),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "tiebreakers",
+			Description: i18n[lang]["opt-tiebreakers"],
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "reset",
+			Description: i18n[lang]["opt-reset"],
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "seed",
+			Description: i18n[lang]["opt-start-seed"],
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "rating-seeds",
+			Description: i18n[lang]["opt-rating-seeds"],
+			Required:    false,
+		},
+		tournamentOption,
+	}
+
+	// /turn-start
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:         "turn-start",
+		Description:  i18n[lang]["turn-start"],
+		DMPermission: &allow,
+		Options:      startOptions,
+	})
+
+	// /turn-preview
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:         "turn-preview",
+		Description:  i18n[lang]["turn-preview"],
+		DMPermission: &allow,
+		Options:      startOptions,
+	})
+
+	// /turn-draw
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:         "turn-draw",
+		Description:  i18n[lang]["turn-draw"],
+		DMPermission: &allow,
+		Options: []*discordgo.ApplicationCommandOption{
 			{
 				Type:        discordgo.ApplicationCommandOptionInteger,
-				Name:        "finals-bestof",
-				Description: i18n[lang]["opt-finals-bestof"],
-				Required:    true,
-				Choices:     GenChoices([]string{"1", "3", "5", "7", "9"}),
+				Name:        "seed",
+				Description: i18n[lang]["opt-draw-seed"],
+				Required:    false,
 			},
+			tournamentOption,
 		},
 	})
-	if err != nil {
-		return fmt.Errorf("error creating command: %w", err)
-	}
 
 	// /turn-result
-	_, err = dg.ApplicationCommandCreate(bot.AppId, bot.GuildId, &discordgo.ApplicationCommand{
+	definitions = append(definitions, &discordgo.ApplicationCommand{
 		Name:         "turn-result",
 		Description:  i18n[lang]["turn-result"],
 		DMPermission: &deny,
 		Options: []*discordgo.ApplicationCommandOption{
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "p1",
-				Description: i18n[lang]["opt-p1"],
-				Required:    true,
-				Choices:     GenChoices(bot.Participants),
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "p1",
+				Description:  i18n[lang]["opt-p1"],
+				Required:     true,
+				Autocomplete: true,
 			},
 			{
 				Type:        discordgo.ApplicationCommandOptionInteger,
@@ -185,11 +489,11 @@ func (bot *TurnvaterBot) ReRegisterCommands() error {
 				Required:    true,
 			},
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "p2",
-				Description: i18n[lang]["opt-p2"],
-				Required:    true,
-				Choices:     GenChoices(bot.Participants),
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "p2",
+				Description:  i18n[lang]["opt-p2"],
+				Required:     true,
+				Autocomplete: true,
 			},
 			{
 				Type:        discordgo.ApplicationCommandOptionInteger,
@@ -197,73 +501,103 @@ func (bot *TurnvaterBot) ReRegisterCommands() error {
 				Description: i18n[lang]["opt-score2"],
 				Required:    true,
 			},
+			tournamentOption,
 		},
 	})
-	if err != nil {
-		return fmt.Errorf("error creating command: %w", err)
-	}
 
 	// /turn-games
-	_, err = dg.ApplicationCommandCreate(bot.AppId, bot.GuildId, &discordgo.ApplicationCommand{
+	definitions = append(definitions, &discordgo.ApplicationCommand{
 		Name:         "turn-games",
 		Description:  i18n[lang]["turn-games"],
 		DMPermission: &allow,
+		Options:      []*discordgo.ApplicationCommandOption{tournamentOption},
 	})
-	if err != nil {
-		return fmt.Errorf("error creating command: %w", err)
-	}
 
 	// /turn-table
-	_, err = dg.ApplicationCommandCreate(bot.AppId, bot.GuildId, &discordgo.ApplicationCommand{
+	definitions = append(definitions, &discordgo.ApplicationCommand{
 		Name:         "turn-table",
 		Description:  i18n[lang]["turn-table"],
 		DMPermission: &allow,
 		Options: []*discordgo.ApplicationCommandOption{
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "group",
-				Description: "Group",
-				Required:    true,
-				Choices:     GenChoices(bot.Groups),
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "group",
+				Description:  "Group",
+				Required:     true,
+				Autocomplete: true,
 			},
+			tournamentOption,
 		},
 	})
-	if err != nil {
-		return fmt.Errorf("error creating command: %w", err)
-	}
 
 	// /turn-close-group
-	_, err = dg.ApplicationCommandCreate(bot.AppId, bot.GuildId, &discordgo.ApplicationCommand{
-		Name:                     "turn-close-group",
-		Description:              i18n[lang]["turn-close-group"],
-		DefaultMemberPermissions: &permAdmin,
-		DMPermission:             &allow,
+	definitions = append(definitions, &discordgo.ApplicationCommand{
+		Name:         "turn-close-group",
+		Description:  i18n[lang]["turn-close-group"],
+		DMPermission: &allow,
 		Options: []*discordgo.ApplicationCommandOption{
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "group",
-				Description: "Group",
-				Required:    true,
-				Choices:     GenChoices(bot.Groups),
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "group",
+				Description:  "Group",
+				Required:     true,
+				Autocomplete: true,
 			},
+			tournamentOption,
 		},
 	})
-	if err != nil {
-		return fmt.Errorf("error creating command: %w", err)
-	}
 
-	fmt.Println("Commands registered.")
+	return definitions
+}
 
-	return nil
+// pendingGuilds takes the guilds whose commands are to be refreshed
+func (bot *TurnvaterBot) pendingGuilds() []string {
+	bot.pending.Lock()
+	defer bot.pending.Unlock()
+	var guilds []string
+	for guildId := range bot.pending.guilds {
+		guilds = append(guilds, guildId)
+	}
+	sort.Strings(guilds)
+	bot.pending.guilds = make(map[string]bool)
+	return guilds
 }
 
+// Run refreshes the commands when asked to, until CTRL-C or another term signal is received
 func (bot *TurnvaterBot) Run() {
-	// Wait here until CTRL-C or other term signal is received.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
 	fmt.Println("Bot is now running. Press CTRL-C to exit.")
 	for {
-		if bot.Restart {
-			bot.Restart = false
-			bot.ReRegisterCommands()
+		select {
+		case <-bot.refresh:
+			for _, guildId := range bot.pendingGuilds() {
+				err := bot.registerCommands(bot.Session, guildId)
+				if err != nil {
+					fmt.Println("error refreshing commands", err)
+				}
+			}
+		case sig := <-stop:
+			fmt.Println("Received", sig, "shutting down.")
+			return
+		}
+	}
+}
+
+// Close removes the commands if configured, and closes the session once the interactions being handled are done
+func (bot *TurnvaterBot) Close() error {
+	if bot.RemoveCommands {
+		for _, guildId := range bot.commandTargets() {
+			_, err := bot.Session.ApplicationCommandBulkOverwrite(bot.AppId, guildId, []*discordgo.ApplicationCommand{})
+			if err != nil {
+				fmt.Println("error removing commands", err)
+			}
 		}
 	}
+	err := bot.Session.Close()
+	// no new interactions arrive, wait for the running ones. The lock is kept, as the database is closed next.
+	handling.Lock()
+	return err
 }
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Format describes how a tournament is played: which groups and matches are
+// created when it starts, how the winner of a group is decided and what
+// happens once a group is complete.
+type Format interface {
+	// Plan generates the initial groups and matches for the participants
+	Plan(participants []string, settings Settings) (Plan, error)
+	// Winner decides the standing of a group
+	Winner(db DBTX, groupId int) (Standing, error)
+	// Complete closes a group and advances its winners. Advancing to group 0 means winning the tournament.
+	Complete(db DBTX, groupId int) ([]Advance, *Standing, error)
+}
+
+var formats = map[string]Format{
+	"groups": GroupFormat{},
+	"swiss":  SwissFormat{},
+	"double": KnockoutFormat{Double: true},
+	"single": KnockoutFormat{},
+}
+
+func FormatNames() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Settings are chosen with /turn-start and stored in the options table
+type Settings struct {
+	Format    string
+	GroupSize int64
+	BestOf    int64
+	Finals    int64
+	Rounds    int64  // swiss only, 0 picks a suitable number
+	Reset     bool   // double elimination only, replay the grand final if the losers bracket wins it
+	Fill      string // groups only: "reduce" the number of groups to a power of two, or fill the knockout with "byes" or "wildcards"
+	Advance   int64  // groups only, players advancing from each group, 0 picks a suitable number
+	// criteria to rank the players of a group, see ParseTiebreakers
+	Tiebreakers []string
+	// seed of the seeded participants, read from the participants table when the tournament starts
+	Seeds map[string]int
+	// RatingSeeds seeds the participants by their rating instead, the seeds are stored when the tournament starts
+	RatingSeeds bool
+	// DrawSeed makes the random draw reproducible, a random one is chosen when the tournament starts
+	DrawSeed int64
+	// Lang is the language of the guild, used for the names of the groups and the errors
+	Lang string
+}
+
+// Rand is the source of randomness for the draw
+func (s Settings) Rand() *rand.Rand {
+	return rand.New(rand.NewSource(s.DrawSeed))
+}
+
+// AdvancePerGroup is the number of players advancing from each group.
+// Unless configured, the first two advance if the declared group size is 4 or more, otherwise only the winner advances.
+func (s Settings) AdvancePerGroup() int {
+	if s.Advance > 0 {
+		return int(s.Advance)
+	}
+	if s.GroupSize > 3 {
+		return 2
+	}
+	return 1
+}
+
+// Slot is one side of a planned match: either a participant, a placeholder
+// for the player reaching the given rank in another group of the plan, or a wildcard.
+type Slot struct {
+	Player   string
+	Group    int // 1-based index into Plan.Groups, 0 if Player is set
+	Rank     int
+	Wildcard int
+}
+
+// Placeholder stands in for the player reaching a rank in a group until the group is complete
+func Placeholder(groupId, rank int) string {
+	if rank > 1 {
+		return fmt.Sprintf("!G%d.%d", groupId, rank)
+	}
+	return fmt.Sprintf("!G%d", groupId)
+}
+
+type PlannedMatch struct {
+	BestOf  int64
+	Player1 Slot
+	Player2 Slot
+}
+
+type PlannedGroup struct {
+	Name         string
+	Participants []string
+	Matches      []PlannedMatch
+}
+
+type Plan struct {
+	// Lang is the language the slots are described in
+	Lang   string
+	Groups []PlannedGroup
+	// the players reaching WildcardRank in these groups compete for the wildcards
+	Wildcards    []int
+	WildcardRank int
+}
+
+// AddGroup appends a group and returns its 1-based index
+func (p *Plan) AddGroup(name string, participants []string, matches ...PlannedMatch) int {
+	p.Groups = append(p.Groups, PlannedGroup{Name: name, Participants: participants, Matches: matches})
+	return len(p.Groups)
+}
+
+// GroupFormat plays round robin qualification groups, followed by a knockout tree of the group winners.
+type GroupFormat struct{}
+
+func (GroupFormat) Plan(participants []string, settings Settings) (Plan, error) {
+	lang := settings.Lang
+	plan := Plan{Lang: lang}
+	groupsize := int(settings.GroupSize)
+	if groupsize < 2 {
+		return plan, errors.New(i18n[lang]["err-groupsize"])
+	}
+	numGroups := len(participants) / groupsize
+	if numGroups < 1 {
+		return plan, fmt.Errorf(i18n[lang]["err-too-few"], groupsize)
+	}
+	if settings.AdvancePerGroup() >= groupsize {
+		return plan, fmt.Errorf(i18n[lang]["err-advance"], groupsize, groupsize-1)
+	}
+	// reduce number of groups to 2^x, unless the knockout is filled up with byes or wildcards
+	if numGroups&(numGroups-1) != 0 && (settings.Fill == "" || settings.Fill == "reduce") {
+		reduced := 1
+		for reduced < numGroups {
+			reduced *= 2
+		}
+		numGroups = reduced / 2
+	}
+
+	// shuffle, seeded players first
+	participants = DrawOrder(participants, settings.Seeds, settings.Rand())
+	// assign to groups, named alphabetically
+	for i := 1; i <= numGroups; i++ {
+		plan.AddGroup(fmt.Sprintf("Gruppe %c", 'A'+i-1), nil)
+	}
+	for k, p := range participants {
+		i := k % numGroups
+		if len(settings.Seeds) > 0 && (k/numGroups)%2 == 1 {
+			// snake draw, the group with the weakest seed gets the strongest player of the next pot
+			i = numGroups - 1 - i
+		}
+		group := &plan.Groups[i]
+		group.Participants = append(group.Participants, p)
+	}
+	// populate matches: create one match per pairing in each group
+	for i := range plan.Groups {
+		group := &plan.Groups[i]
+		for j := 0; j < len(group.Participants); j++ {
+			for k := j + 1; k < len(group.Participants); k++ {
+				group.Matches = append(group.Matches, PlannedMatch{settings.BestOf, Slot{Player: group.Participants[j]}, Slot{Player: group.Participants[k]}})
+			}
+		}
+	}
+	if numGroups == 1 {
+		// the winner of the only group wins the tournament
+		return plan, nil
+	}
+
+	GroupKnockout(&plan, numGroups, settings.AdvancePerGroup(), settings)
+	return plan, nil
+}
+
+// DrawOrder shuffles the participants within their pots: the seeded players in the order of their seed,
+// players sharing a seed in random order, followed by the unseeded players in random order.
+// The same participants and source always give the same order.
+func DrawOrder(participants []string, seeds map[string]int, rng *rand.Rand) []string {
+	order := append([]string{}, participants...)
+	sort.Strings(order)
+	rng.Shuffle(len(order), func(i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+	pot := func(p string) int {
+		if seed, ok := seeds[p]; ok && seed > 0 {
+			return seed
+		}
+		return math.MaxInt
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return pot(order[i]) < pot(order[j])
+	})
+	return order
+}
+
+// SlotName describes a slot by the name of the group it comes from
+func (p Plan) SlotName(s Slot) string {
+	if s.Wildcard > 0 || s.Group == 0 {
+		if s.Player != "" && s.Player != Bye {
+			return s.Player
+		}
+		return s.Label(p.Lang)
+	}
+	return fmt.Sprintf(i18n[p.Lang]["slot-rank-of"], s.Rank, p.Groups[s.Group-1].Name)
+}
+
+// Label describes a slot of a planned knockout match
+func (s Slot) Label(lang string) string {
+	if s.Wildcard > 0 {
+		return fmt.Sprintf(i18n[lang]["slot-wildcard"], s.Wildcard)
+	}
+	if s.Group == 0 {
+		return i18n[lang]["slot-bye"]
+	}
+	return fmt.Sprintf(i18n[lang][fmt.Sprintf("slot-rank-%d", s.Rank)], 'A'+s.Group-1)
+}
+
+// GroupKnockout adds a knockout tree for the players advancing from the first numGroups groups of the plan.
+// If their number is not a power of two, the bracket is filled up with wildcards for the best of the next ranked players, or with byes.
+func GroupKnockout(plan *Plan, numGroups, advance int, settings Settings) {
+	lang := settings.Lang
+	slots := numGroups * advance
+	size := 2
+	for size < slots {
+		size *= 2
+	}
+	wildcards := 0
+	if settings.Fill == "wildcards" && size > slots {
+		wildcards = size - slots
+		for i := 1; i <= numGroups; i++ {
+			plan.Wildcards = append(plan.Wildcards, i)
+		}
+		plan.WildcardRank = advance + 1
+	}
+
+	// cross seed the group winners against the lower ranks of other groups, the best seeds meet the byes
+	order := BracketOrder(size)
+	seeds := groupSeeds(numGroups, advance, 0, wildcards, size)
+	for offset := 0; offset < numGroups; offset++ {
+		rotated := groupSeeds(numGroups, advance, offset, wildcards, size)
+		if !sameGroupPairs(rotated, order) {
+			seeds = rotated
+			break
+		}
+	}
+
+	var round []int
+	for k := 0; k < size; k += 2 {
+		a, b := seeds[order[k]-1], seeds[order[k+1]-1]
+		round = append(round, plan.AddGroup(fmt.Sprintf(i18n[lang]["knockout-match"], 'A'+len(plan.Groups), a.Label(lang), b.Label(lang)), nil,
+			PlannedMatch{settings.Finals, a, b}))
+	}
+	for len(round) > 1 {
+		var next []int
+		for k := 0; k < len(round); k += 2 {
+			next = append(next, plan.AddGroup(fmt.Sprintf(i18n[lang]["winner-groups"], 'A'+len(plan.Groups), 'A'+round[k]-1, 'A'+round[k+1]-1), nil,
+				PlannedMatch{settings.Finals, Slot{Group: round[k], Rank: 1}, Slot{Group: round[k+1], Rank: 1}}))
+		}
+		round = next
+	}
+}
+
+// groupSeeds lists the group winners in order, followed by the lower ranks rotated by offset, the wildcards and the byes
+func groupSeeds(numGroups, advance, offset, wildcards, size int) []Slot {
+	var seeds []Slot
+	for rank := 1; rank <= advance; rank++ {
+		for i := 0; i < numGroups; i++ {
+			group := i
+			if rank > 1 {
+				group = (i + offset) % numGroups
+			}
+			seeds = append(seeds, Slot{Group: group + 1, Rank: rank})
+		}
+	}
+	for w := 1; w <= wildcards; w++ {
+		seeds = append(seeds, Slot{Wildcard: w})
+	}
+	for len(seeds) < size {
+		seeds = append(seeds, Slot{Player: Bye})
+	}
+	return seeds
+}
+
+// sameGroupPairs checks whether two players of the same group would meet in the first round
+func sameGroupPairs(seeds []Slot, order []int) bool {
+	for k := 0; k < len(order); k += 2 {
+		a, b := seeds[order[k]-1], seeds[order[k+1]-1]
+		if a.Group > 0 && a.Group == b.Group {
+			return true
+		}
+	}
+	return false
+}
+
+func (GroupFormat) Winner(db DBTX, groupId int) (Standing, error) {
+	places := DBGetSettings(db, DBGroupTournament(db, groupId)).AdvancePerGroup()
+	// the player reaching the wildcard rank competes for a wildcard, a tie for that rank is played out as well
+	if rank := dbWildcardRank(db, groupId); rank > places {
+		places = rank
+	}
+	return DBCalcWinner(db, groupId, places)
+}
+
+// dbAdvancingPlaces is the number of places of a group that advance to other groups, at least the winner
+func dbAdvancingPlaces(db DBTX, groupId int) int {
+	var rank1, rank2 int
+	db.QueryRow(`SELECT coalesce(max(CASE WHEN source1_group = ? THEN source1_rank END), 0), coalesce(max(CASE WHEN source2_group = ? THEN source2_rank END), 0)
+		FROM matches WHERE source1_group = ? OR source2_group = ?`, groupId, groupId, groupId, groupId).Scan(&rank1, &rank2)
+	return max(1, rank1, rank2)
+}
+
+// checkRanking makes sure a standing has a player for each place that advances from the group, before any of them advances
+func checkRanking(db DBTX, groupId int, standing Standing) error {
+	if places := dbAdvancingPlaces(db, groupId); len(standing.Ranking) < places {
+		return fmt.Errorf(i18n[DBTournamentLang(db, DBGroupTournament(db, groupId))]["err-ranking"], len(standing.Ranking), places)
+	}
+	return nil
+}
+
+func (f GroupFormat) Complete(db DBTX, groupId int) ([]Advance, *Standing, error) {
+	standing, err := f.Winner(db, groupId)
+	var draw *DrawError
+	if errors.As(err, &draw) {
+		// the tied players play it out, the group stays open
+		return nil, nil, DBScheduleTiebreak(db, groupId, draw)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkRanking(db, groupId, standing)
+	if err != nil {
+		return nil, nil, err
+	}
+	tournamentId := DBGroupTournament(db, groupId)
+	lang := DBTournamentLang(db, tournamentId)
+
+	// mark group as complete, will have failed if there is no winner in the previous step
+	_, err = db.Exec("UPDATE groups SET complete = 1 WHERE id = ?", groupId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// advance players to the next group(s)
+	next, err := DBAdvance(db, groupId, 1, standing.Ranking[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if next.Id == 0 {
+		next.Name = i18n[lang]["tournament-winner"]
+	}
+	winners := []Advance{{Player: standing.Ranking[0], Group: next}}
+	for rank := 2; rank <= len(standing.Ranking); rank++ {
+		next, err = DBAdvance(db, groupId, rank, standing.Ranking[rank-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		if next.Id == 0 {
+			break
+		}
+		winners = append(winners, Advance{Player: standing.Ranking[rank-1], Group: next})
+	}
+
+	// once all groups are complete, the best of the next ranked players fill the remaining slots
+	err = DBResolveWildcards(db, tournamentId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return winners, &standing, nil
+}
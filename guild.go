@@ -0,0 +1,105 @@
+package main
+
+// GuildSettings are the settings of one guild served by the bot, empty values fall back to the .settings file
+type GuildSettings struct {
+	GuildId           string
+	Lang              string
+	ParticipantRoleId string
+	// OrganizerRoleId is the role allowed to run the tournaments of the guild besides its admins, see organizerCommands
+	OrganizerRoleId string
+}
+
+// organizerCommands may be used by the organizer role, unless the guild chose another role for them
+var organizerCommands = []string{"turn-reset", "turn-start", "turn-close-group"}
+
+// roleCommands are the commands a guild may choose a role for, /turn-guild itself stays with the admins
+var roleCommands = []string{"turn-reset", "turn-channel", "turn-seed", "turn-start", "turn-preview", "turn-draw", "turn-result", "turn-close-group"}
+
+// DBGetGuild returns the settings of a guild, a guild without settings uses the defaults
+func DBGetGuild(db DBTX, guildId string) GuildSettings {
+	guild := GuildSettings{GuildId: guildId}
+	db.QueryRow("SELECT lang, participant_role_id, organizer_role_id FROM guilds WHERE guild_id = ?", guildId).Scan(&guild.Lang, &guild.ParticipantRoleId, &guild.OrganizerRoleId)
+	return guild
+}
+
+// DBSetGuild stores the settings of a guild
+func DBSetGuild(db DBTX, guild GuildSettings) error {
+	_, err := db.Exec(`INSERT INTO guilds (guild_id, lang, participant_role_id, organizer_role_id) VALUES (?, ?, ?, ?)
+		ON CONFLICT(guild_id) DO UPDATE SET lang = excluded.lang, participant_role_id = excluded.participant_role_id, organizer_role_id = excluded.organizer_role_id`,
+		guild.GuildId, guild.Lang, guild.ParticipantRoleId, guild.OrganizerRoleId)
+	return err
+}
+
+// DBGetCommandRoles returns the roles a guild chose for single commands, by command name
+func DBGetCommandRoles(db DBTX, guildId string) map[string]string {
+	roles := make(map[string]string)
+	rows, err := db.Query("SELECT command, role_id FROM command_roles WHERE guild_id = ? ORDER BY command", guildId)
+	if err != nil {
+		return roles
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var command, roleId string
+		if rows.Scan(&command, &roleId) == nil {
+			roles[command] = roleId
+		}
+	}
+	return roles
+}
+
+// DBSetCommandRole lets a role use a command in a guild, an empty role removes the choice
+func DBSetCommandRole(db DBTX, guildId, command, roleId string) error {
+	if roleId == "" {
+		_, err := db.Exec("DELETE FROM command_roles WHERE guild_id = ? AND command = ?", guildId, command)
+		return err
+	}
+	_, err := db.Exec("INSERT INTO command_roles (guild_id, command, role_id) VALUES (?, ?, ?) ON CONFLICT(guild_id, command) DO UPDATE SET role_id = excluded.role_id",
+		guildId, command, roleId)
+	return err
+}
+
+// GuildLang is the language of a guild, or the default language of the bot
+func GuildLang(guildId string) string {
+	if l := DBGetGuild(backend, guildId).Lang; l != "" {
+		return l
+	}
+	return defaultLang
+}
+
+// DBTournamentLang is the language of the guild a tournament belongs to
+func DBTournamentLang(db DBTX, tournamentId int) string {
+	if l := DBGetGuild(db, DBGetTournamentGuild(db, tournamentId)).Lang; l != "" {
+		return l
+	}
+	return defaultLang
+}
+
+// GuildParticipantRole is the role given to registered participants in a guild
+func GuildParticipantRole(guildId string) string {
+	if role := DBGetGuild(backend, guildId).ParticipantRoleId; role != "" {
+		return role
+	}
+	return turnvater.ParticipantRoleId
+}
+
+// GuildOrganizerRole is the role of the tournament organizers in a guild
+func GuildOrganizerRole(guildId string) string {
+	if role := DBGetGuild(backend, guildId).OrganizerRoleId; role != "" {
+		return role
+	}
+	return turnvater.OrganizerRoleId
+}
+
+// CommandRole is the role which may use a command in a guild besides the admins: the role chosen for the command,
+// or the organizer role for the organizerCommands. Other commands are reserved to admins.
+func CommandRole(guildId, command string) string {
+	if role, ok := DBGetCommandRoles(backend, guildId)[command]; ok {
+		return role
+	}
+	for _, c := range organizerCommands {
+		if c == command {
+			return GuildOrganizerRole(guildId)
+		}
+	}
+	return ""
+}
@@ -3,6 +3,21 @@ package main
 var i18n = map[string]map[string]string{
 	"de": {
 		"turn-reset":           "Turnier zurücksetzen",
+		"turn-channel":         "Standardturnier dieses Kanals festlegen",
+		"opt-tournament":       "Turnier, standardmäßig das des Kanals",
+		"info-tournament-id":   "Es ist jetzt das Standardturnier dieses Kanals (#%d).",
+		"err-tournament":       "Dieses Turnier gibt es nicht.",
+		"err-channel":          "Fehler beim Festlegen des Standardturniers.",
+		"ok-channel":           "'%s' (#%d) ist jetzt das Standardturnier dieses Kanals.",
+		"turn-guild":           "Sprache und Rollen dieses Servers festlegen",
+		"opt-lang":             "Sprache des Bots auf diesem Server",
+		"opt-participant-role": "Rolle, die angemeldete Teilnehmer erhalten",
+		"ok-guild":             "Sprache: %s, Teilnehmerrolle: %s, Organisatorrolle: %s",
+		"opt-organizer-role":   "Rolle der Turnierorganisatoren, die Turniere neu starten, beginnen und Gruppen schließen darf",
+		"opt-command":          "Befehl, für den eine Rolle gewählt wird",
+		"opt-command-role":     "Rolle, die den Befehl benutzen darf, ohne Rolle gilt wieder die Vorgabe",
+		"err-guild":            "Fehler beim Speichern der Einstellungen.",
+		"guild-no-role":        "keine",
 		"turn-register":        "Anmelden",
 		"turn-status":          "Status des Turniers",
 		"turn-start":           "Turnier starten",
@@ -20,15 +35,55 @@ var i18n = map[string]map[string]string{
 		"err-not-allowed":      "Du darfst das nicht.",
 		"err-not-started":      "Das Turnier hat noch nicht begonnen.",
 		"err-started":          "Das Turnier hat bereits begonnen.",
+		"turn-seed":            "Spieler für die Auslosung setzen",
+		"opt-player":           "Spieler",
+		"opt-seed":             "Setzposition, gleiche Zahl bildet einen Topf, 0 entfernt die Setzung",
+		"ok-seed":              "%s ist auf Position %d gesetzt.",
+		"ok-unseed":            "%s ist nicht mehr gesetzt.",
+		"err-seed":             "%s ist nicht angemeldet.",
 		"err-reset":            "Fehler beim Anlegen des Turniers.",
 		"err-no-match":         "Paarung nicht gefunden.",
 		"err-score-total":      "Die Summe der Punkte ist nicht korrekt. Wir spielen Best of %d.",
+		"err-knockout-tie":     "Ein K.-o.-Spiel braucht einen Sieger, bitte tragt ein Ergebnis ohne Unentschieden ein.",
 		"err-set-score":        "Fehler beim Setzen des Ergebnisses.",
 		"err-get-games":        "Fehler beim Abrufen der Spiele.",
 		"ok-set-score":         "Ergebnis wurde gespeichert.",
+		"info-report":          "%s meldet %s vs %s: %d-%d. %s, bitte bestätigen oder anfechten.",
+		"btn-confirm":          "Bestätigen",
+		"btn-dispute":          "Anfechten",
+		"ok-confirm":           "%s hat das Ergebnis bestätigt.",
+		"ok-dispute":           "%s ficht das Ergebnis %s vs %s: %d-%d an. %s, bitte prüfen.",
+		"err-report":           "Diese Meldung ist nicht mehr offen.",
+		"err-not-opponent":     "Nur der Gegner kann dieses Ergebnis bestätigen oder anfechten.",
+		"err-not-player":       "Nur die Spieler dieses Spiels und Admins können sein Ergebnis eintragen.",
+		"report-admins":        "Admins",
 		"ok-reset":             "Neues Turnier '%s' wurde initialisiert.",
 		"err-start":            "Fehler beim Starten des Turniers.",
 		"ok-start":             "Turnier wurde mit Gruppengrösse %d gestartet.",
+		"ok-start-format":      "Turnier wurde gestartet: %s.",
+		"turn-history":         "Frühere Turniere anzeigen",
+		"history":              "Turniere",
+		"history-tournament":   "#%d %s **%s**: %d Teilnehmer, %s",
+		"history-winner":       "Sieger: %s",
+		"err-history":          "Fehler beim Lesen der Turniere:",
+		"opt-rating-seeds":     "Spieler nach ihrer Wertung setzen statt nach /turn-seed",
+		"turn-rating":          "Elo-Wertung aller Spieler, oder der Verlauf eines Spielers",
+		"opt-rating-player":    "Spieler, dessen Verlauf angezeigt wird",
+		"rating-leaderboard":   "Elo-Wertung",
+		"rating-player":        "%s: %.0f (%d Spiele)",
+		"rating-change":        "    %+.1f gegen %s (%s) → %.0f",
+		"err-rating":           "Fehler beim Lesen der Wertung:",
+		"turn-preview":         "Auslosung und Turnierbaum ansehen, ohne das Turnier zu starten",
+		"opt-start-seed":       "Seed der Auslosung, z.B. aus /turn-preview",
+		"preview-summary":      "%d Gruppen, %d Spiele",
+		"preview-group":        "**%s** (%d Spieler, %d Spiele): %s",
+		"preview-wildcards":    "%d Wildcards für die besten Platzierten auf Rang %d",
+		"slot-rank-of":         "%d. aus %s",
+		"info-draw-seed":       "Ausgelost mit Seed %s, nachprüfbar mit /turn-draw.",
+		"turn-draw":            "Auslosung für einen Seed wiederholen, ohne etwas zu ändern",
+		"opt-draw-seed":        "Seed der Auslosung, standardmässig der des laufenden Turniers",
+		"info-draw":            "Auslosung mit Seed %d:",
+		"err-draw":             "Fehler bei der Auslosung:",
 		"err-register":         "Fehler bei der Anmeldung:",
 		"err-register-name":    "Fehler bei der Anmeldung: Das Zeichen ! ist zur Zeit nicht zulässig.",
 		"welcome":              "Willkommen beim Turnier, %s. Du bist jetzt mit dem Nick %s angemeldet!",
@@ -37,23 +92,68 @@ var i18n = map[string]map[string]string{
 		"desc-status":          "Zeigt den Status des Turniers an",
 		"opt-name":             "Name des Turniers",
 		"opt-ign":              "In-Game Name (Nick)",
-		"opt-groupsize":        "Gruppengrösse",
+		"opt-groupsize":        "Gruppengrösse, nur für die Gruppenphase",
 		"opt-bestof":           "Best of X",
 		"opt-finals-bestof":    "Best of X (Finale)",
+		"opt-format":           "Turniermodus",
+		"format-groups":        "Gruppenphase mit K.O.-Runde",
+		"err-too-few":          "Zu wenige Teilnehmer für Gruppengrösse %d.",
+		"err-groupsize":        "Für die Gruppenphase wird eine Gruppengrösse benötigt.",
+		"format-swiss":         "Schweizer System",
+		"opt-rounds":           "Anzahl Runden (Schweizer System)",
+		"err-rounds":           "Zu viele Runden, höchstens %d Runden sind ohne Wiederholungen möglich.",
+		"swiss-round":          "Runde %d",
+		"info-games":           "Die neuen Paarungen zeigt `/turn-games`.",
+		"summary-score-swiss":  "%s: %d Siege, %d Buchholz, %d Punktdifferenz, %d Punkte",
+		"format-double":        "Doppel-K.O.",
+		"format-single":        "K.O.-System ohne Gruppenphase",
+		"bracket-round":        "K.O.-Runde %d, Spiel %d",
+		"opt-fill":             "Gruppenanzahl, die keine Zweierpotenz ist (Gruppenphase)",
+		"fill-reduce":          "Weniger, grössere Gruppen",
+		"opt-advance":          "Anzahl Aufsteiger pro Gruppe (Gruppenphase)",
+		"err-advance":          "Aus Gruppen mit %d Spielern können höchstens %d aufsteigen.",
+		"fill-byes":            "Freilose in der K.O.-Runde",
+		"fill-wildcards":       "Wildcards für die besten Nächstplatzierten",
+		"info-grouping-fill":   "Gruppengrösse %d mit Freilosen oder Wildcards: %d Gruppen, %d freie Plätze in der K.O.-Runde",
+		"opt-reset":            "Grosses Finale wiederholen, wenn der Sieger des unteren Baums gewinnt (Doppel-K.O.)",
+		"bracket-winners":      "Oberer Baum, Runde %d, Spiel %d",
+		"bracket-losers":       "Unterer Baum, Runde %d, Spiel %d",
+		"bracket-final":        "Grosses Finale",
+		"bracket-reset":        "Grosses Finale, Wiederholung",
+		"win-by-4":             "Buchholz",
+		"win-by-5":             "direkter Vergleich",
+		"win-by-6":             "Mini-Tabelle",
+		"win-by-7":             "Losentscheid",
+		"win-by-8":             "Entscheidungsspiel",
+		"summary-tiebreak":     " (Entscheidungsspiel)",
+		"info-tiebreak":        "Gleichstand um Platz %d. Die Entscheidungsspiele %s werden nur für die Auflösung des Gleichstands gewertet, siehe /turn-games.",
+		"table-win-by":         " (vorne durch %s)",
+		"table-tie":            " (gleichauf)",
+		"opt-tiebreakers":      "Kriterien bei Gleichstand, z.B. wins,diff,h2h (wins, diff, points, h2h, mini, draw)",
+		"err-tiebreakers":      "Unbekanntes oder doppeltes Kriterium '%s'.",
 		"opt-p1":               "Spieler 1",
 		"opt-p2":               "Spieler 2",
 		"opt-score1":           "Punkte Spieler 1",
 		"opt-score2":           "Punkte Spieler 2",
 		"winner-groups":        "Gruppe %c: Sieger %c-%c",
-		"winner-second":        "Gruppe %c: Sieger %c - Zweiter %c",
+		"knockout-match":       "Gruppe %c: %s - %s",
+		"slot-rank-1":          "Sieger %c",
+		"slot-rank-2":          "Zweiter %c",
+		"slot-rank-3":          "Dritter %c",
+		"slot-rank-4":          "Vierter %c",
+		"slot-wildcard":        "Wildcard %d",
+		"slot-bye":             "Freilos",
 		"ok-group-winner":      "%s hat sich in '%s' durchgesetzt und steigt auf zu '%s'",
 		"ok-group-second":      "%s ist in '%s' Zweiter und steigt auf zu '%s'",
+		"ok-group-rank":        "%s ist %d. in '%s' und steigt auf zu '%s'",
 		"ok-close-group":       "%s wurde abgeschlossen.",
-		"info-current-leaders": "**Aktuell Führende:** %s (%d %s), %s (%d %s)",
-		"perfect-draw-first":   "Unentschieden um den ersten Platz. Um das Unentschieden aufzulösen, spielt bitte weitere Spiele und ernennt einen Sieger, indem ihr ein(!) Ergebnis aktualisiert.",
-		"perfect-draw-second":  "Unentschieden um den zweiten Platz. Um das Unentschieden aufzulösen, spielt bitte weitere Spiele und ernennt einen Sieger, indem ihr ein(!) Ergebnis aktualisiert.",
+		"err-close-group":      "Fehler beim Abschliessen der Gruppe:",
+		"info-current-leaders": "**Aktuell Führende:** %s, %s",
+		"perfect-draw":         "Unentschieden um Platz %d. Es wird in den angesetzten Entscheidungsspielen aufgelöst, siehe /turn-games.",
 		"tournament-winner":    "Turniersieger",
 		"err-group-complete":   "Fehler beim Überprüfen der Gruppe: ",
+		"err-group-waiting":    "Die Gruppe wartet noch auf Spieler aus anderen Gruppen.",
+		"err-ranking":          "Die Rangliste der Gruppe hat nur %d Spieler, aber %d Plätze steigen auf.",
 		"congratulate":         "Herzlichen Glückwunsch, %s! Du bist Turniersieger!",
 		"summary-group":        "%s:",
 		"summary-match":        "    %s: %d - %d: %s",
@@ -65,6 +165,21 @@ var i18n = map[string]map[string]string{
 	},
 	"en": {
 		"turn-reset":           "Reset tournament",
+		"turn-channel":         "Set the default tournament of this channel",
+		"opt-tournament":       "Tournament, by default the one of the channel",
+		"info-tournament-id":   "It is now the default tournament of this channel (#%d).",
+		"err-tournament":       "This tournament does not exist.",
+		"err-channel":          "Error setting the default tournament.",
+		"ok-channel":           "'%s' (#%d) is now the default tournament of this channel.",
+		"turn-guild":           "Set the language and roles of this server",
+		"opt-lang":             "Language of the bot on this server",
+		"opt-participant-role": "Role given to registered participants",
+		"ok-guild":             "Language: %s, participant role: %s, organizer role: %s",
+		"opt-organizer-role":   "Role of the tournament organizers, who may reset and start tournaments and close groups",
+		"opt-command":          "Command to choose a role for",
+		"opt-command-role":     "Role allowed to use the command, without a role the default applies again",
+		"err-guild":            "Error saving the settings.",
+		"guild-no-role":        "none",
 		"turn-register":        "Register",
 		"turn-status":          "Status of the tournament",
 		"turn-start":           "Start tournament",
@@ -82,16 +197,57 @@ var i18n = map[string]map[string]string{
 		"err-not-allowed":      "You are not allowed to do that.",
 		"err-not-started":      "The tournament has not started yet.",
 		"err-started":          "The tournament has already started.",
+		"turn-seed":            "Seed a player for the draw",
+		"opt-player":           "Player",
+		"opt-seed":             "Seed, players with the same seed form a pot, 0 removes the seed",
+		"ok-seed":              "%s is seeded %d.",
+		"ok-unseed":            "%s is no longer seeded.",
+		"err-seed":             "%s is not registered.",
 		"err-reset":            "Error creating the tournament.",
 		"err-no-match":         "Match not found.",
 		"err-score-total":      "The sum of the scores is not correct. We play Best of %d.",
+		"err-knockout-tie":     "A knockout match needs a winner, please enter a result without a draw.",
 		"err-set-score":        "Error setting the score.",
 		"err-get-games":        "Error getting the games.",
 		"ok-set-score":         "Score has been saved.",
+		"info-report":          "%s reported %s vs %s: %d-%d. %s, please confirm or dispute.",
+		"btn-confirm":          "Confirm",
+		"btn-dispute":          "Dispute",
+		"ok-confirm":           "%s confirmed the result.",
+		"ok-dispute":           "%s disputed the result %s vs %s: %d-%d. %s, please check.",
+		"err-report":           "This report is not open anymore.",
+		"err-not-opponent":     "Only the opponent can confirm or dispute this result.",
+		"err-not-player":       "Only the players of this match and admins can enter its result.",
+		"report-admins":        "Admins",
 		"ok-reset":             "New tournament '%s' has been initialized.",
 		"err-start":            "Error starting the tournament.",
 		"ok-start":             "Tournament started with group size %d.",
+		"ok-start-format":      "Tournament started: %s.",
+		"turn-history":         "Show past tournaments",
+		"history":              "Tournaments",
+		"history-tournament":   "#%d %s **%s**: %d participants, %s",
+		"history-winner":       "winner: %s",
+		"err-history":          "Error reading the tournaments:",
+		"opt-rating-seeds":     "Seed players by their rating instead of /turn-seed",
+		"turn-rating":          "Elo rating of all players, or the history of one player",
+		"opt-rating-player":    "Player whose history is shown",
+		"rating-leaderboard":   "Elo rating",
+		"rating-player":        "%s: %.0f (%d games)",
+		"rating-change":        "    %+.1f against %s (%s) → %.0f",
+		"err-rating":           "Error reading the rating:",
+		"turn-preview":         "Preview the draw and bracket without starting the tournament",
+		"opt-start-seed":       "Seed of the draw, e.g. from /turn-preview",
+		"preview-summary":      "%d groups, %d matches",
+		"preview-group":        "**%s** (%d players, %d matches): %s",
+		"preview-wildcards":    "%d wildcards for the best players ranked %d",
+		"slot-rank-of":         "Rank %d of %s",
+		"info-draw-seed":       "Drawn with seed %s, verify with /turn-draw.",
+		"turn-draw":            "Repeat the draw for a seed without changing anything",
+		"opt-draw-seed":        "Seed of the draw, defaults to the one of the running tournament",
+		"info-draw":            "Draw with seed %d:",
+		"err-draw":             "Error drawing the tournament:",
 		"ok-close-group":       "%s has been closed.",
+		"err-close-group":      "Error closing the group:",
 		"err-register":         "Error registering:",
 		"err-register-name":    "Error registering: The character ! is currently not allowed.",
 		"welcome":              "Welcome to the tournament, %s. You are now registered with the nick %s!",
@@ -100,22 +256,66 @@ var i18n = map[string]map[string]string{
 		"desc-status":          "Shows the status of the tournament",
 		"opt-name":             "Name of the tournament",
 		"opt-ign":              "In-Game Name (Nick)",
-		"opt-groupsize":        "Group size",
+		"opt-groupsize":        "Group size, groups only",
 		"opt-bestof":           "Best of X",
 		"opt-finals-bestof":    "Best of X (Finals)",
+		"opt-format":           "Tournament format",
+		"format-groups":        "Group stage with knockout",
+		"err-too-few":          "Too few participants for group size %d.",
+		"err-groupsize":        "The group stage needs a group size.",
+		"format-swiss":         "Swiss system",
+		"opt-rounds":           "Number of rounds (Swiss system)",
+		"err-rounds":           "Too many rounds, at most %d rounds are possible without rematches.",
+		"swiss-round":          "Round %d",
+		"info-games":           "See `/turn-games` for the new pairings.",
+		"summary-score-swiss":  "%s: %d wins, %d Buchholz, %d score difference, %d points",
+		"format-double":        "Double elimination",
+		"format-single":        "Single elimination without group stage",
+		"bracket-round":        "Knockout round %d, match %d",
+		"opt-fill":             "Number of groups that is not a power of two (group stage)",
+		"fill-reduce":          "Fewer, larger groups",
+		"opt-advance":          "Players advancing from each group (group stage)",
+		"err-advance":          "At most %[2]d players can advance from groups of %[1]d.",
+		"fill-byes":            "Byes in the knockout",
+		"fill-wildcards":       "Wildcards for the best next ranked players",
+		"info-grouping-fill":   "Group size %d with byes or wildcards: %d groups, %d free slots in the knockout",
+		"opt-reset":            "Replay the grand final if the losers bracket wins it (double elimination)",
+		"bracket-winners":      "Winners bracket, round %d, match %d",
+		"bracket-losers":       "Losers bracket, round %d, match %d",
+		"bracket-final":        "Grand final",
+		"bracket-reset":        "Grand final, bracket reset",
+		"win-by-4":             "Buchholz",
+		"win-by-5":             "head-to-head",
+		"win-by-6":             "mini table",
+		"win-by-7":             "coin flip",
+		"win-by-8":             "tiebreak match",
+		"summary-tiebreak":     " (tiebreak)",
+		"info-tiebreak":        "Tie for place %d. The tiebreak matches %s only count to break the tie, see /turn-games.",
+		"table-win-by":         " (ahead by %s)",
+		"table-tie":            " (tied)",
+		"opt-tiebreakers":      "Criteria to break ties, e.g. wins,diff,h2h (wins, diff, points, h2h, mini, draw)",
+		"err-tiebreakers":      "Unknown or duplicate criterion '%s'.",
 		"opt-p1":               "Player 1",
 		"opt-p2":               "Player 2",
 		"opt-score1":           "Score Player 1",
 		"opt-score2":           "Score Player 2",
 		"winner-groups":        "Group %c: Winner %c-%c",
-		"winner-second":        "Group %c: Winner %c - Second %c",
+		"knockout-match":       "Group %c: %s - %s",
+		"slot-rank-1":          "Winner %c",
+		"slot-rank-2":          "Second %c",
+		"slot-rank-3":          "Third %c",
+		"slot-rank-4":          "Fourth %c",
+		"slot-wildcard":        "Wildcard %d",
+		"slot-bye":             "Bye",
 		"ok-group-winner":      "%s has won group '%s' and advances to '%s'",
 		"ok-group-second":      "%s is second in group '%s' and advances to '%s'",
-		"info-current-leaders": "Current leaders: %s (%d %s), %s (%d %s)",
-		"perfect-draw-first":   "Draw for first place. To resolve the draw, please play more games and declare a winner by updating one(!) single result.",
-		"perfect-draw-second":  "Draw for second place. To resolve the draw, please play more games and declare a winner by updating one(!) single result.",
+		"ok-group-rank":        "%s is number %d in group '%s' and advances to '%s'",
+		"info-current-leaders": "Current leaders: %s, %s",
+		"perfect-draw":         "Draw for place %d. It is resolved by the scheduled tiebreak matches, see /turn-games.",
 		"tournament-winner":    "Tournament winner",
 		"err-group-complete":   "Error checking group: ",
+		"err-group-waiting":    "The group is still waiting for players from other groups.",
+		"err-ranking":          "The ranking of the group has only %d players, but %d places advance.",
 		"congratulate":         "Congratulations, %s! You are the tournament winner!",
 		"summary-group":        "%s:",
 		"summary-match":        "    %s: %d - %d: %s",
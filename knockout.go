@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KnockoutFormat seeds all participants into a bracket of single matches, missing players are filled up with byes.
+// In double elimination, the losers of the winners bracket drop into a losers bracket, whose winner meets the
+// winner of the winners bracket in the grand final.
+type KnockoutFormat struct {
+	Double bool
+}
+
+// BracketOrder lists the seeds in the order of their bracket positions, so that the best seeds meet last
+func BracketOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		n := len(order) * 2
+		next := make([]int, 0, n)
+		for _, s := range order {
+			next = append(next, s, n+1-s)
+		}
+		order = next
+	}
+	return order
+}
+
+// SeedBracket adds the first round of a bracket for the players in order of their seed, and returns the group indices of its matches.
+// The top seeds meet a bye if the number of players is not a power of two.
+func SeedBracket(plan *Plan, players []string, bestof int64, name func(match int) string) []int {
+	size := 2
+	for size < len(players) {
+		size *= 2
+	}
+	order := BracketOrder(size)
+	var round []int
+	for k := 0; k < size; k += 2 {
+		p1 := players[order[k]-1]
+		p2 := Bye
+		participants := []string{p1}
+		if order[k+1] <= len(players) {
+			p2 = players[order[k+1]-1]
+			participants = append(participants, p2)
+		}
+		round = append(round, plan.AddGroup(name(k/2+1), participants, PlannedMatch{bestof, Slot{Player: p1}, Slot{Player: p2}}))
+	}
+	return round
+}
+
+func (f KnockoutFormat) Plan(participants []string, settings Settings) (Plan, error) {
+	lang := settings.Lang
+	plan := Plan{Lang: lang}
+	if len(participants) < 2 {
+		return plan, fmt.Errorf(i18n[lang]["err-too-few"], 2)
+	}
+	// the best seeds meet byes and each other last
+	players := DrawOrder(participants, settings.Seeds, settings.Rand())
+
+	bracket := "bracket-round"
+	if f.Double {
+		bracket = "bracket-winners"
+	}
+	winnersName := func(round int) func(int) string {
+		return func(match int) string {
+			return fmt.Sprintf(i18n[lang][bracket], round, match)
+		}
+	}
+	round := SeedBracket(&plan, players, settings.BestOf, winnersName(1))
+	winners := [][]int{round}
+	for r := 2; len(round) > 1; r++ {
+		var next []int
+		for k := 0; k < len(round); k += 2 {
+			next = append(next, plan.AddGroup(winnersName(r)(k/2+1), nil, PlannedMatch{settings.BestOf, Slot{Group: round[k], Rank: 1}, Slot{Group: round[k+1], Rank: 1}}))
+		}
+		winners = append(winners, next)
+		round = next
+	}
+	if !f.Double {
+		return plan, nil
+	}
+
+	// the losers bracket starts with the losers of the first round
+	losersName := func(round, match int) string {
+		return fmt.Sprintf(i18n[lang]["bracket-losers"], round, match)
+	}
+	challenger := Slot{Group: round[0], Rank: 2}
+	if len(winners) > 1 {
+		lbRound := 1
+		var prev []int
+		for k := 0; k < len(winners[0]); k += 2 {
+			prev = append(prev, plan.AddGroup(losersName(lbRound, k/2+1), nil, PlannedMatch{settings.BestOf, Slot{Group: winners[0][k], Rank: 2}, Slot{Group: winners[0][k+1], Rank: 2}}))
+		}
+		for r := 1; r < len(winners); r++ {
+			// survivors meet the losers of the next winners bracket round, in reverse order to avoid early rematches
+			lbRound++
+			dropped := winners[r]
+			var major []int
+			for k := range prev {
+				major = append(major, plan.AddGroup(losersName(lbRound, k+1), nil, PlannedMatch{settings.BestOf, Slot{Group: prev[k], Rank: 1}, Slot{Group: dropped[len(dropped)-1-k], Rank: 2}}))
+			}
+			prev = major
+			if len(prev) > 1 {
+				lbRound++
+				var minor []int
+				for k := 0; k < len(prev); k += 2 {
+					minor = append(minor, plan.AddGroup(losersName(lbRound, k/2+1), nil, PlannedMatch{settings.BestOf, Slot{Group: prev[k], Rank: 1}, Slot{Group: prev[k+1], Rank: 1}}))
+				}
+				prev = minor
+			}
+		}
+		challenger = Slot{Group: prev[0], Rank: 1}
+	}
+	plan.AddGroup(i18n[lang]["bracket-final"], nil, PlannedMatch{settings.Finals, Slot{Group: round[0], Rank: 1}, challenger})
+	return plan, nil
+}
+
+// knockoutMatch reads the only match of a knockout group and decides winner and loser
+func knockoutMatch(db DBTX, groupId int) (Match, Standing, error) {
+	lang := DBTournamentLang(db, DBGroupTournament(db, groupId))
+	matches := DBGetMatches(db, 0, groupId)
+	if len(matches) != 1 {
+		return Match{}, Standing{}, fmt.Errorf(i18n[lang]["err-no-match"])
+	}
+	m := matches[0]
+	if m.Score1 == m.Score2 {
+		return m, Standing{}, errors.New(i18n[lang]["err-knockout-tie"])
+	}
+	standing := Standing{Ranking: []string{m.Player1, m.Player2}, WinBy: []WinBy{WinByWins, WinByWins}, Scores: []int{m.Score1, m.Score2}}
+	if m.Score2 > m.Score1 {
+		standing = Standing{Ranking: []string{m.Player2, m.Player1}, WinBy: []WinBy{WinByWins, WinByWins}, Scores: []int{m.Score2, m.Score1}}
+	}
+	return m, standing, nil
+}
+
+func (KnockoutFormat) Winner(db DBTX, groupId int) (Standing, error) {
+	_, standing, err := knockoutMatch(db, groupId)
+	return standing, err
+}
+
+func (f KnockoutFormat) Complete(db DBTX, groupId int) ([]Advance, *Standing, error) {
+	m, standing, err := knockoutMatch(db, groupId)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkRanking(db, groupId, standing)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = db.Exec("UPDATE groups SET complete = 1 WHERE id = ?", groupId)
+	if err != nil {
+		return nil, nil, err
+	}
+	tournamentId := DBGroupTournament(db, groupId)
+	winner, loser := standing.Ranking[0], standing.Ranking[1]
+	next, err := DBAdvance(db, groupId, 1, winner)
+	if err != nil {
+		return nil, nil, err
+	}
+	if next.Id == 0 {
+		settings := DBGetSettings(db, tournamentId)
+		lang := settings.Lang
+		if f.Double && settings.Reset && winner == m.Player2 && DBGetOption(db, tournamentId, "bracket-reset") != "1" {
+			// the winner of the losers bracket beat the undefeated player, so both have lost once: play again
+			err = DBSetOption(db, tournamentId, "bracket-reset", "1")
+			if err != nil {
+				return nil, nil, err
+			}
+			plan := Plan{Lang: lang}
+			reset := Group{Name: i18n[lang]["bracket-reset"]}
+			plan.AddGroup(reset.Name, []string{m.Player1, m.Player2}, PlannedMatch{settings.Finals, Slot{Player: m.Player1}, Slot{Player: m.Player2}})
+			err = DBWritePlan(db, tournamentId, plan)
+			if err != nil {
+				return nil, nil, err
+			}
+			reset.Id, err = DBGetGroupByName(db, tournamentId, reset.Name)
+			if err != nil {
+				return nil, nil, err
+			}
+			return []Advance{{Player: winner, Group: reset}, {Player: loser, Group: reset}}, &standing, nil
+		}
+		next.Name = i18n[lang]["tournament-winner"]
+		return []Advance{{Player: winner, Group: next}}, &standing, nil
+	}
+	winners := []Advance{{Player: winner, Group: next}}
+	// in double elimination, the loser drops into the losers bracket
+	dropped, err := DBAdvance(db, groupId, 2, loser)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dropped.Id > 0 && loser != Bye {
+		winners = append(winners, Advance{Player: loser, Group: dropped})
+	}
+	return winners, &standing, nil
+}
@@ -7,6 +7,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/bwmarrin/discordgo"
 	_ "modernc.org/sqlite"
@@ -20,14 +23,37 @@ var (
 	turnvater *TurnvaterBot
 	permAdmin int64 = discordgo.PermissionAdministrator
 
-	lang = "de"
+	// defaultLang is the language of guilds without their own, the handlers use the language of the guild of their interaction
+	defaultLang = "de"
+	// interactions are handled concurrently while holding a read lock, the lock is taken once the bot shuts down
+	handling sync.RWMutex
 )
 
-func HasPermission(dg *discordgo.Session, member *discordgo.Member, guildID, permission string) bool {
+// adminOnly is the permission of actions no role may be chosen for
+const adminOnly = "ADMINISTRATOR"
+
+// HasPermission tells whether a member may use a command: admins may use all of them, other members need the
+// CommandRole of the command in the guild. Pass adminOnly for actions reserved to admins.
+func HasPermission(dg *discordgo.Session, member *discordgo.Member, guildID, command string) bool {
+	if member == nil {
+		return false
+	}
+	// discord sends the permissions of the member along with interactions
+	if member.Permissions&permAdmin == permAdmin {
+		return true
+	}
+	var required string
+	if command != adminOnly {
+		required = CommandRole(guildID, command)
+	}
 	for _, roleID := range member.Roles {
+		if roleID == required {
+			return true
+		}
 		role, err := dg.State.Role(guildID, roleID)
 		if err != nil {
-			return false
+			// a role missing from the state grants nothing, but the other roles may
+			continue
 		}
 		if role.Permissions&permAdmin == permAdmin {
 			return true
@@ -36,6 +62,44 @@ func HasPermission(dg *discordgo.Session, member *discordgo.Member, guildID, per
 	return false
 }
 
+// RefereeMentions mentions the roles which settle disputed results in a guild: the roles with admin permissions,
+// the role chosen for /turn-result and the organizer role
+func RefereeMentions(dg *discordgo.Session, guildID, lang string) string {
+	var roles []*discordgo.Role
+	if guild, err := dg.State.Guild(guildID); err == nil {
+		roles = guild.Roles
+	} else {
+		roles, _ = dg.GuildRoles(guildID)
+	}
+	var mentions []string
+	for _, role := range roles {
+		// the role of everyone has the id of the guild
+		if role.Permissions&permAdmin == permAdmin && role.ID != guildID {
+			mentions = append(mentions, "<@&"+role.ID+">")
+		}
+	}
+	for _, id := range []string{CommandRole(guildID, "turn-result"), GuildOrganizerRole(guildID)} {
+		if mention := "<@&" + id + ">"; id != "" && !slices.Contains(mentions, mention) {
+			mentions = append(mentions, mention)
+		}
+	}
+	if len(mentions) == 0 {
+		return i18n[lang]["report-admins"]
+	}
+	return strings.Join(mentions, ", ")
+}
+
+// UpdateMessage replaces the message whose button was used, without its buttons
+func UpdateMessage(dg *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	dg.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+}
+
 func Respond(dg *discordgo.Session, i *discordgo.InteractionCreate, content string) {
 	dg.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -45,6 +109,31 @@ func Respond(dg *discordgo.Session, i *discordgo.InteractionCreate, content stri
 	})
 }
 
+// OptionMap returns the options of a command by name, optional options that were not given are missing
+func OptionMap(i *discordgo.InteractionCreate) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range i.ApplicationCommandData().Options {
+		options[option.Name] = option
+	}
+	return options
+}
+
+// SelectTournament decides which tournament a command applies to: the one chosen with the tournament option,
+// the default of the channel, or the one started last in the guild. Tournaments of other guilds cannot be selected, 0 is returned instead.
+func SelectTournament(i *discordgo.InteractionCreate) int {
+	if tournament, ok := OptionMap(i)["tournament"]; ok {
+		id := int(tournament.IntValue())
+		if DBGetTournamentGuild(backend, id) != i.GuildID {
+			return 0
+		}
+		return id
+	}
+	if id := DBGetChannelTournament(backend, i.ChannelID); id > 0 && DBGetTournamentGuild(backend, id) == i.GuildID {
+		return id
+	}
+	return DBTournamentId(backend, i.GuildID)
+}
+
 func CalcGroups(num, groupsize int) (int, int) {
 	groups := num / groupsize
 	rest := num % groupsize
@@ -53,17 +142,12 @@ func CalcGroups(num, groupsize int) (int, int) {
 
 /* === Main Loop === */
 
-func ReRegister() {
-	turnvater.Participants = DBGetParticipants(backend, 0)
-	turnvater.Restart = true
-}
-
 func main() {
-	// read lang from environment or command line
+	// read the default lang from environment or command line, guilds may choose their own with /turn-guild
 	if os.Getenv("LANG") == "de" || os.Getenv("LANG") == "en" {
-		lang = os.Getenv("LANG")
+		defaultLang = os.Getenv("LANG")
 	} else if len(os.Args) > 1 {
-		lang = os.Args[1]
+		defaultLang = os.Args[1]
 	}
 
 	// read settings from .settings file
@@ -88,23 +172,39 @@ func main() {
 		fmt.Println("appId not found in settings")
 		return
 	}
-	guildId, ok := settings["guildId"]
-	if !ok {
-		fmt.Println("guildId not found in settings")
-		return
-	}
 	state, ok := settings["state"]
 	if !ok {
 		fmt.Println("state not found in settings")
 		return
 	}
-	pRoleId, ok := settings["participantRoleId"]
-	if !ok {
-		fmt.Println("participantRoleId not found in settings")
+	if l, ok := settings["lang"]; ok {
+		defaultLang = l
+	}
+	// the guilds to register the commands in, separated by commas. Without guilds, the commands are global.
+	var guildIds []string
+	for _, guildId := range strings.Split(settings["guildId"], ",") {
+		if guildId = strings.TrimSpace(guildId); guildId != "" {
+			guildIds = append(guildIds, guildId)
+		}
+	}
+	global := len(guildIds) == 0
+	switch settings["commands"] {
+	case "global":
+		global = true
+	case "guild":
+		if global {
+			fmt.Println("commands are registered per guild, but guildId not found in settings")
+			return
+		}
+	case "":
+	default:
+		fmt.Println("commands must be global or guild in settings")
 		return
 	}
+	// the default participant role, guilds may choose their own with /turn-guild
+	pRoleId := settings["participantRoleId"]
 
-	db, err := sql.Open("sqlite", state)
+	db, err := OpenDB(state)
 	if err != nil {
 		fmt.Println("error opening database", err)
 		return
@@ -112,20 +212,37 @@ func main() {
 	defer db.Close()
 
 	backend = db
+	// bring the state file up to date before anything reads it
+	err = DBMigrate(backend)
+	if err != nil {
+		fmt.Println("error migrating database", err)
+		return
+	}
 
-	participants := DBGetParticipants(backend, 0)
-	groups := DBGetGroups(backend)
-	groupNames := make([]string, len(groups))
-	for i, group := range groups {
-		groupNames[i] = group.Name
+	if len(guildIds) == 1 {
+		// the tournaments of a version serving a single guild belong to it
+		err = DBAdoptGuild(backend, guildIds[0])
+		if err != nil {
+			fmt.Println("error initializing database", err)
+			return
+		}
 	}
 
-	bot, err := NewBot(token, appId, guildId, participants, pRoleId, groupNames)
+	// the default organizer role, guilds may choose their own with /turn-guild
+	oRoleId := settings["organizerRoleId"]
+	// the handlers use turnvater as soon as the session is open
+	turnvater = NewBot(token, appId, guildIds, global, pRoleId, oRoleId)
+	turnvater.RemoveCommands = settings["removeCommands"] == "true"
+	err = turnvater.Open()
 	if err != nil {
 		fmt.Println("error running bot", err)
 		return
 	}
-	turnvater = &bot
 
-	bot.Run()
+	turnvater.Run()
+	// the database is closed after the session, by the deferred db.Close
+	err = turnvater.Close()
+	if err != nil {
+		fmt.Println("error closing discord connection", err)
+	}
 }
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// testAdminRole is the role with admin permissions in the test guild
+const testAdminRole = "admins"
+
+// fakeDiscord answers the requests of a session instead of discord, and keeps the content of the responses, the suggested choices and the custom ids of buttons
+type fakeDiscord struct {
+	sync.Mutex
+	responses []string
+	choices   [][]string
+	buttons   []string
+}
+
+func (f *fakeDiscord) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body struct {
+		Type int
+		Data struct {
+			Content string
+			Choices []struct {
+				Name string
+			}
+			Components []struct {
+				Components []struct {
+					CustomID string `json:"custom_id"`
+				}
+			}
+		}
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	f.Lock()
+	if body.Data.Content != "" {
+		f.responses = append(f.responses, body.Data.Content)
+	}
+	if body.Type == int(discordgo.InteractionApplicationCommandAutocompleteResult) {
+		var names []string
+		for _, choice := range body.Data.Choices {
+			names = append(names, choice.Name)
+		}
+		f.choices = append(f.choices, names)
+	}
+	for _, row := range body.Data.Components {
+		for _, button := range row.Components {
+			f.buttons = append(f.buttons, button.CustomID)
+		}
+	}
+	f.Unlock()
+	return &http.Response{StatusCode: http.StatusNoContent, Header: make(http.Header), Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+}
+
+// FakeSession is a session whose requests are answered by fake, knowing the admin role of the test guild
+func FakeSession(t *testing.T, fake *fakeDiscord) *discordgo.Session {
+	dg, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatalf("Error creating session: %s", err)
+	}
+	dg.Client = &http.Client{Transport: fake}
+	dg.State.GuildAdd(&discordgo.Guild{ID: testGuild, Roles: []*discordgo.Role{{ID: testAdminRole, Permissions: permAdmin}}})
+	return dg
+}
+
+// Command is the interaction of a member using a slash command in the test guild
+func Command(user, name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        "interaction-" + user,
+		Token:     "token",
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   testGuild,
+		ChannelID: "channel",
+		Member:    &discordgo.Member{User: &discordgo.User{ID: user, Username: user}},
+		Data:      discordgo.ApplicationCommandInteractionData{Name: name, Options: options},
+	}}
+}
+
+// Admin gives the member of an interaction the admin role
+func Admin(i *discordgo.InteractionCreate) *discordgo.InteractionCreate {
+	i.Member.Roles = []string{testAdminRole}
+	return i
+}
+
+// Click is the interaction of a member using the button with a custom id
+func Click(user, customID string) *discordgo.InteractionCreate {
+	i := Command(user, "")
+	i.Type = discordgo.InteractionMessageComponent
+	i.Data = discordgo.MessageComponentInteractionData{CustomID: customID, ComponentType: discordgo.ButtonComponent}
+	return i
+}
+
+// Typing is the interaction of a member typing the option focused of a slash command
+func Typing(name string, focused int, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	i := Command("typist", name, options...)
+	i.Type = discordgo.InteractionApplicationCommandAutocomplete
+	options[focused].Focused = true
+	return i
+}
+
+func StringOption(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+func IntOption(name string, value int) *discordgo.ApplicationCommandInteractionDataOption {
+	// discord sends numbers as JSON
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionInteger, Value: float64(value)}
+}
+
+func TestTranslations(t *testing.T) {
+	// every key the code looks up is translated into every language
+	key := regexp.MustCompile(`i18n\[[^\]]+\]\["([^"]+)"\]`)
+	files, _ := filepath.Glob("*.go")
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", file, err)
+		}
+		for _, match := range key.FindAllStringSubmatch(string(source), -1) {
+			for lang, texts := range i18n {
+				if _, ok := texts[match[1]]; !ok {
+					t.Errorf("%s uses %s, which is missing in %s", file, match[1], lang)
+				}
+			}
+		}
+	}
+}
+
+// TestConcurrentHandlers runs the tournaments of two guilds with different languages at once.
+// The interactions of each phase start together, results of the same match compete for the per-tournament lock.
+func TestConcurrentHandlers(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+	backend = db
+	turnvater = &TurnvaterBot{GuildIds: []string{testGuild}}
+	fake := &fakeDiscord{}
+	dg := FakeSession(t, fake)
+	const otherGuild = "other"
+	dg.State.GuildAdd(&discordgo.Guild{ID: otherGuild, Roles: []*discordgo.Role{{ID: testAdminRole, Permissions: permAdmin}}})
+	if err := DBSetGuild(db, GuildSettings{GuildId: otherGuild, Lang: "en"}); err != nil {
+		t.Fatalf("Error setting the language: %s", err)
+	}
+	guilds := []string{testGuild, otherGuild}
+	tids := make(map[string]int)
+	for _, guild := range guilds {
+		tids[guild], _ = DBResetTournament(db, guild, "handlers")
+	}
+
+	var wg sync.WaitGroup
+	var start chan struct{}
+	run := func(guild string, i *discordgo.InteractionCreate) {
+		i.GuildID = guild
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			HandleInteraction(dg, i)
+		}()
+	}
+	phase := func(queue func(guild string)) {
+		start = make(chan struct{})
+		for _, guild := range guilds {
+			queue(guild)
+		}
+		close(start)
+		wg.Wait()
+	}
+
+	// players register at the same time, while others look at the status and type their names
+	phase(func(guild string) {
+		for k := 0; k < 8; k++ {
+			run(guild, Command(fmt.Sprintf("user%d", k), "turn-register", StringOption("ign", fmt.Sprintf("ign%d", k))))
+			run(guild, Command("viewer", "turn-status"))
+			run(guild, Typing("turn-seed", 0, StringOption("player", "ign")))
+		}
+	})
+	for _, guild := range guilds {
+		if participants := DBGetParticipants(db, tids[guild], 0); len(participants) != 8 {
+			t.Fatalf("Expected 8 participants in %s, got %v", guild, participants)
+		}
+		if err := DBStartTournament(db, tids[guild], Settings{Format: "single", BestOf: 3, Finals: 3, DrawSeed: 1}); err != nil {
+			t.Fatalf("Error starting tournament: %s", err)
+		}
+	}
+
+	// admins enter the result of the same match at once, while others list the games and type the opponents
+	firsts := make(map[string]Group)
+	fake.responses, fake.choices = nil, nil
+	phase(func(guild string) {
+		first := DBGetGroups(db, tids[guild])[0]
+		firsts[guild] = first
+		p1, p2 := first.Participants[0], first.Participants[1]
+		for k := 0; k < 8; k++ {
+			run(guild, Admin(Command(fmt.Sprintf("admin%d", k), "turn-result", StringOption("p1", p1), IntOption("score1", 2), StringOption("p2", p2), IntOption("score2", 0))))
+			run(guild, Command("viewer", "turn-games"))
+			run(guild, Typing("turn-result", 1, StringOption("p1", p1), StringOption("p2", "")))
+		}
+	})
+	if len(fake.responses) != 32 || len(fake.choices) != 16 {
+		t.Errorf("Expected 32 responses and 16 suggestions, got %d and %d", len(fake.responses), len(fake.choices))
+	}
+
+	for _, guild := range guilds {
+		first := firsts[guild]
+		p1 := first.Participants[0]
+		var next Group
+		for _, g := range DBGetGroups(db, tids[guild]) {
+			for _, p := range g.Participants {
+				if p == p1 {
+					next = g
+				}
+			}
+		}
+		if next.Id == 0 || next.Id == first.Id {
+			t.Fatalf("Expected %s to advance in %s, got %v", p1, guild, next)
+		}
+		// each guild is answered in its own language
+		promotion := PromotionMessage(Advance{Player: p1, Group: next}, first.Name, 1, GuildLang(guild))
+		promoted := 0
+		for _, response := range fake.responses {
+			if strings.Contains(response, promotion) {
+				promoted++
+			}
+		}
+		if promoted != 1 {
+			t.Errorf("Expected the promotion in %s to be announced once, got %d announcements of %q", guild, promoted, promotion)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+	backend = db
+	bot := NewBot("token", "app", []string{testGuild, "other"}, false, "", "")
+	// several handlers asking for new choices at once cause a single refresh, of the guilds they asked for
+	for k := 0; k < 3; k++ {
+		bot.Refresh(testGuild)
+	}
+	bot.Refresh("unknown")
+	if len(bot.refresh) != 1 {
+		t.Errorf("Expected one pending refresh, got %d", len(bot.refresh))
+	}
+	if guilds := bot.pendingGuilds(); fmt.Sprint(guilds) != "["+testGuild+"]" {
+		t.Errorf("Expected to refresh %s only, got %v", testGuild, guilds)
+	}
+	// global commands are not refreshed for a guild
+	global := NewBot("token", "app", nil, true, "", "")
+	global.Refresh(testGuild)
+	if len(global.refresh) != 0 {
+		t.Errorf("Expected no refresh of global commands")
+	}
+	// a bot not created by NewBot ignores refreshes
+	(&TurnvaterBot{}).Refresh(testGuild)
+
+	// the commands are described in the language of their guild
+	if err := DBSetGuild(db, GuildSettings{GuildId: "other", Lang: "en"}); err != nil {
+		t.Fatalf("Error setting the language: %s", err)
+	}
+	for _, guild := range []string{testGuild, "other"} {
+		definitions := CommandDefinitions(guild)
+		if len(definitions) != len(commands) {
+			t.Errorf("Expected %d commands, got %d", len(commands), len(definitions))
+		}
+		for _, command := range definitions {
+			if command.Description != i18n[GuildLang(guild)][command.Name] {
+				t.Errorf("Unexpected description of /%s in %s: %s", command.Name, guild, command.Description)
+			}
+			// discord hides commands with default permissions from the roles chosen with /turn-guild, only /turn-guild itself stays with the admins
+			if admin := command.DefaultMemberPermissions != nil; admin != (command.Name == "turn-guild") {
+				t.Errorf("Unexpected default permissions of /%s: %v", command.Name, command.DefaultMemberPermissions)
+			}
+		}
+	}
+}
+
+func TestStartGroupSize(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+	backend = db
+	turnvater = &TurnvaterBot{}
+	fake := &fakeDiscord{}
+	dg := FakeSession(t, fake)
+	lang := GuildLang(testGuild)
+
+	start := func(format string) string {
+		DBResetTournament(db, testGuild, format)
+		for k := 0; k < 4; k++ {
+			HandleInteraction(dg, Command(fmt.Sprintf("user%d", k), "turn-register", StringOption("ign", fmt.Sprintf("ign%d", k))))
+		}
+		fake.responses = nil
+		HandleInteraction(dg, Admin(Command("admin", "turn-start", IntOption("bestof", 3), IntOption("finals-bestof", 3), StringOption("format", format))))
+		if len(fake.responses) != 1 {
+			t.Fatalf("Expected one response, got %v", fake.responses)
+		}
+		return fake.responses[0]
+	}
+	// the group size is only needed for groups
+	if response := start("single"); !strings.HasPrefix(response, fmt.Sprintf(i18n[lang]["ok-start-format"], i18n[lang]["format-single"])) {
+		t.Errorf("Expected a knockout to start without group size, got %s", response)
+	}
+	if response := start("groups"); !strings.Contains(response, i18n[lang]["err-groupsize"]) {
+		t.Errorf("Expected groups to need a group size, got %s", response)
+	}
+}
+
+func TestLeaderLine(t *testing.T) {
+	lang := "en"
+	standing := Standing{Ranking: []string{"a", "b", "c"}, WinBy: []WinBy{WinByHeadToHead, WinByWins, 0}, Scores: []int{7, 2, 2}}
+	// the head-to-head is no score, the number it decided by is left out
+	if line := LeaderLine(standing, 0, lang); line != "a (ahead by head-to-head)" {
+		t.Errorf("Unexpected line of the leader: %s", line)
+	}
+	if line := LeaderLine(standing, 1, lang); line != "b (2 wins)" {
+		t.Errorf("Unexpected line of the second: %s", line)
+	}
+	if line := LeaderLine(standing, 2, lang); line != "c (tied)" {
+		t.Errorf("Unexpected line of the third: %s", line)
+	}
+}
+
+func TestAutocomplete(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+	backend = db
+	fake := &fakeDiscord{}
+	dg := FakeSession(t, fake)
+
+	tid, _ := DBResetTournament(db, testGuild, "autocomplete")
+	for k := 0; k < 30; k++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", k), fmt.Sprintf("ign%02d", k))
+	}
+	suggest := func(i *discordgo.InteractionCreate) []string {
+		fake.choices = nil
+		HandleInteraction(dg, i)
+		if len(fake.choices) != 1 {
+			t.Fatalf("Expected suggestions, got %v", fake.choices)
+		}
+		return fake.choices[0]
+	}
+
+	// discord shows 25 choices at most, typing narrows them down
+	if players := suggest(Typing("turn-seed", 0, StringOption("player", ""))); len(players) != 25 {
+		t.Errorf("Expected 25 players, got %v", players)
+	}
+	if players := suggest(Typing("turn-seed", 0, StringOption("player", "IGN2"))); len(players) != 10 || players[0] != "ign20" {
+		t.Errorf("Expected ign20 to ign29, got %v", players)
+	}
+
+	// once started, results are entered for the opponents in open matches
+	if err := DBStartTournament(db, tid, Settings{Format: "single", BestOf: 3, Finals: 3, DrawSeed: 1}); err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	opponents, _ := DBGetOpponents(db, tid)
+	var p1 string
+	for p := range opponents {
+		p1 = p
+	}
+	if players := suggest(Typing("turn-result", 0, StringOption("p1", ""))); len(players) != min(len(opponents), 25) {
+		t.Errorf("Expected the players of open matches, got %v", players)
+	}
+	players := suggest(Typing("turn-result", 2, StringOption("p1", p1), IntOption("score1", 2), StringOption("p2", "")))
+	if len(players) != 1 || players[0] != opponents[p1][0] {
+		t.Errorf("Expected %s to be suggested as opponent of %s, got %v", opponents[p1][0], p1, players)
+	}
+	// players whose match has a result are not suggested anymore, neither as opponents
+	p2 := opponents[p1][0]
+	if err := DBCreateMatch(db, tid, p1, p2, 2, 0); err != nil {
+		t.Fatalf("Error entering the result: %s", err)
+	}
+	for _, p := range suggest(Typing("turn-result", 0, StringOption("p1", ""))) {
+		if p == p1 || p == p2 {
+			t.Errorf("Expected the players of %s vs %s not to be suggested, got %s", p1, p2, p)
+		}
+	}
+	if players := suggest(Typing("turn-result", 0, StringOption("p1", ""), StringOption("p2", p2))); len(players) != 0 {
+		t.Errorf("Expected no opponents of %s, got %v", p2, players)
+	}
+
+	// the open groups of the tournament
+	groups := suggest(Typing("turn-table", 0, StringOption("group", "")))
+	if len(groups) != min(len(DBGetGroups(db, tid)), 25) {
+		t.Errorf("Expected the open groups, got %v", groups)
+	}
+}
+
+func TestReportButtons(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+	backend = db
+	fake := &fakeDiscord{}
+	dg := FakeSession(t, fake)
+
+	turnvater = &TurnvaterBot{OrganizerRoleId: "organizers"}
+	if err := DBSetCommandRole(db, testGuild, "turn-result", "referees"); err != nil {
+		t.Fatalf("Error choosing the referee role: %s", err)
+	}
+
+	tid, _ := DBResetTournament(db, testGuild, "buttons")
+	for k := 0; k < 4; k++ {
+		DBRegisterParticipant(db, tid, fmt.Sprintf("user%d", k), fmt.Sprintf("ign%d", k))
+	}
+	if err := DBStartTournament(db, tid, Settings{Format: "single", BestOf: 3, Finals: 3, DrawSeed: 1}); err != nil {
+		t.Fatalf("Error starting tournament: %s", err)
+	}
+	groups := DBGetGroups(db, tid)
+	first, second := groups[0], groups[1]
+	p1, p2 := first.Participants[0], first.Participants[1]
+	reporter, opponent := DBGetDiscordId(db, tid, p1), DBGetDiscordId(db, tid, p2)
+	report := func() (string, string) {
+		fake.buttons = nil
+		HandleInteraction(dg, Command(reporter, "turn-result", StringOption("p1", p1), IntOption("score1", 2), StringOption("p2", p2), IntOption("score2", 0)))
+		if len(fake.buttons) != 2 {
+			t.Fatalf("Expected buttons to confirm and dispute, got %v", fake.buttons)
+		}
+		return fake.buttons[0], fake.buttons[1]
+	}
+	click := func(user, customID string) string {
+		fake.responses = nil
+		HandleInteraction(dg, Click(user, customID))
+		if len(fake.responses) != 1 {
+			t.Fatalf("Expected one response, got %v", fake.responses)
+		}
+		return fake.responses[0]
+	}
+	lang := GuildLang(testGuild)
+
+	// members who do not play the match can not enter its result
+	fake.responses = nil
+	HandleInteraction(dg, Command("stranger", "turn-result", StringOption("p1", p1), IntOption("score1", 2), StringOption("p2", p2), IntOption("score2", 0)))
+	if len(fake.responses) != 1 || fake.responses[0] != i18n[lang]["err-not-player"] {
+		t.Errorf("Expected the result of a stranger to be refused, got %v", fake.responses)
+	}
+
+	// knockout matches need a winner, whether a player reports the result or an admin enters it
+	for _, i := range []*discordgo.InteractionCreate{
+		Command(reporter, "turn-result", StringOption("p1", p1), IntOption("score1", 1), StringOption("p2", p2), IntOption("score2", 1)),
+		Admin(Command("admin", "turn-result", StringOption("p1", p1), IntOption("score1", 1), StringOption("p2", p2), IntOption("score2", 1))),
+	} {
+		fake.responses = nil
+		HandleInteraction(dg, i)
+		if len(fake.responses) != 1 || fake.responses[0] != i18n[lang]["err-knockout-tie"] {
+			t.Errorf("Expected a tie to be refused, got %v", fake.responses)
+		}
+	}
+
+	// only the opponent decides about a result reported by a player
+	confirm, dispute := report()
+	if m := DBGetMatches(db, tid, first.Id)[0]; m.Score1+m.Score2 != 0 {
+		t.Errorf("Expected the reported result to wait for the opponent, got %d-%d", m.Score1, m.Score2)
+	}
+	for _, user := range []string{reporter, "stranger"} {
+		if response := click(user, confirm); response != i18n[lang]["err-not-opponent"] {
+			t.Errorf("Expected %s not to confirm, got %s", user, response)
+		}
+	}
+	// admins, the role chosen for /turn-result and the organizers settle the dispute
+	response := click(opponent, dispute)
+	for _, role := range []string{testAdminRole, "referees", "organizers"} {
+		if !strings.Contains(response, "<@&"+role+">") {
+			t.Errorf("Expected the dispute to mention %s, got %s", role, response)
+		}
+	}
+	if response := click(opponent, confirm); !strings.Contains(response, i18n[lang]["err-report"]) {
+		t.Errorf("Expected the disputed report to be closed, got %s", response)
+	}
+
+	// a confirmed result completes the group
+	confirm, _ = report()
+	if response := click(opponent, confirm); !strings.Contains(response, i18n[lang]["ok-set-score"]) || !strings.Contains(response, p1) {
+		t.Errorf("Expected the result to be saved, got %s", response)
+	}
+	if m := DBGetMatches(db, tid, first.Id)[0]; m.Score1+m.Score2 != 2 {
+		t.Errorf("Expected the confirmed score, got %d-%d", m.Score1, m.Score2)
+	}
+
+	// admins enter results directly
+	fake.buttons = nil
+	HandleInteraction(dg, Admin(Command("admin", "turn-result", StringOption("p1", second.Participants[0]), IntOption("score1", 2), StringOption("p2", second.Participants[1]), IntOption("score2", 1))))
+	if m := DBGetMatches(db, tid, second.Id)[0]; len(fake.buttons) != 0 || m.Score1+m.Score2 != 3 {
+		t.Errorf("Expected the result of an admin to be saved, got %d-%d and buttons %v", m.Score1, m.Score2, fake.buttons)
+	}
+}
+
+// permissionCase is a member using a command in a guild, and whether it is allowed
+type permissionCase struct {
+	member  *discordgo.Member
+	guild   string
+	command string
+	allowed bool
+}
+
+func TestHasPermission(t *testing.T) {
+	db := InitDB(t)
+	defer db.Close()
+	backend = db
+	turnvater = &TurnvaterBot{OrganizerRoleId: "organizers"}
+	dg := FakeSession(t, &fakeDiscord{})
+	dg.State.RoleAdd(testGuild, &discordgo.Role{ID: "organizers"})
+	dg.State.RoleAdd(testGuild, &discordgo.Role{ID: "referees"})
+
+	member := func(permissions int64, roles ...string) *discordgo.Member {
+		return &discordgo.Member{User: &discordgo.User{ID: "member"}, Roles: roles, Permissions: permissions}
+	}
+	check := func(step string, cases []permissionCase) {
+		for _, c := range cases {
+			if allowed := HasPermission(dg, c.member, c.guild, c.command); allowed != c.allowed {
+				t.Errorf("%s: expected %v for roles %v using %s in %s, got %v", step, c.allowed, c.member.Roles, c.command, c.guild, allowed)
+			}
+		}
+	}
+
+	check("default organizer role", []permissionCase{
+		{member(0, testAdminRole), testGuild, "turn-seed", true},
+		{member(permAdmin), testGuild, adminOnly, true},
+		// a role missing from the state does not hide the admin role
+		{member(0, "deleted", testAdminRole), testGuild, adminOnly, true},
+		{member(0, "organizers"), testGuild, "turn-start", true},
+		{member(0, "organizers"), testGuild, "turn-reset", true},
+		{member(0, "organizers"), testGuild, "turn-close-group", true},
+		{member(0, "organizers"), testGuild, "turn-seed", false},
+		{member(0, "organizers"), testGuild, adminOnly, false},
+		{member(0, "referees"), testGuild, "turn-start", false},
+		{member(0), testGuild, "turn-start", false},
+	})
+	if HasPermission(dg, nil, testGuild, "turn-start") {
+		t.Errorf("Expected no permissions without a member")
+	}
+
+	// a guild chooses its own organizer role, and roles for single commands
+	DBSetGuild(db, GuildSettings{GuildId: testGuild, OrganizerRoleId: "referees"})
+	DBSetCommandRole(db, testGuild, "turn-result", "referees")
+	DBSetCommandRole(db, testGuild, "turn-close-group", "organizers")
+	check("guild roles", []permissionCase{
+		{member(0, "referees"), testGuild, "turn-start", true},
+		{member(0, "referees"), testGuild, "turn-result", true},
+		{member(0, "referees"), testGuild, "turn-close-group", false},
+		{member(0, "organizers"), testGuild, "turn-close-group", true},
+		{member(0, "organizers"), testGuild, "turn-start", false},
+		// other guilds keep the default
+		{member(0, "organizers"), "other-guild", "turn-start", true},
+		{member(0, "referees"), "other-guild", "turn-start", false},
+	})
+
+	// without a role, the command goes back to the default
+	DBSetCommandRole(db, testGuild, "turn-close-group", "")
+	check("removed command role", []permissionCase{
+		{member(0, "referees"), testGuild, "turn-close-group", true},
+		{member(0, "organizers"), testGuild, "turn-close-group", false},
+	})
+}
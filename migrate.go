@@ -0,0 +1,407 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Migration upgrades the schema of the state file by one version.
+// Databases of versions before schema_version existed were upgraded on the fly, so that a migration
+// may find its changes already applied: migrations have to check before they change anything.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(db DBTX) error
+}
+
+// migrations are applied in order, new migrations are appended with the next version
+var migrations = []Migration{
+	{1, "options, participants, groups and matches", migrateBaseline},
+	{2, "tiebreak matches", func(db DBTX) error {
+		return dbAddColumn(db, "matches", "tiebreak", "INTEGER DEFAULT 0")
+	}},
+	{3, "seeds", func(db DBTX) error {
+		return dbAddColumn(db, "participants", "seed", "INTEGER DEFAULT 0")
+	}},
+	{4, "ratings", migrateRatings},
+	{5, "tournament history", migrateTournaments},
+	{6, "channel tournaments", func(db DBTX) error {
+		_, err := db.Exec("CREATE TABLE IF NOT EXISTS channel_tournaments (channel_id TEXT PRIMARY KEY NOT NULL, tournament_id INTEGER NOT NULL)")
+		return err
+	}},
+	{7, "guilds", migrateGuilds},
+	{8, "participant ids and slot sources", migrateSlots},
+	{9, "result reports", func(db DBTX) error {
+		_, err := db.Exec(`CREATE TABLE IF NOT EXISTS reports (
+			id INTEGER PRIMARY KEY,
+			match_id INTEGER NOT NULL REFERENCES matches(id),
+			reporter_id TEXT NOT NULL,
+			score1 INTEGER NOT NULL,
+			score2 INTEGER NOT NULL,
+			status TEXT DEFAULT 'pending',
+			created DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+		return err
+	}},
+	{10, "organizer roles", func(db DBTX) error {
+		err := dbAddColumn(db, "guilds", "organizer_role_id", "TEXT DEFAULT ''")
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("CREATE TABLE IF NOT EXISTS command_roles (guild_id TEXT NOT NULL, command TEXT NOT NULL, role_id TEXT NOT NULL, PRIMARY KEY (guild_id, command))")
+		return err
+	}},
+	{11, "ratings by member", migrateRatingMembers},
+}
+
+// SchemaVersion is the version of the schema this version of the bot works with
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// DBSchemaVersion is the version of the schema of a database, 0 if it was never migrated
+func DBSchemaVersion(db DBTX) (int, error) {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER PRIMARY KEY, description TEXT NOT NULL, applied DATETIME DEFAULT CURRENT_TIMESTAMP)")
+	if err != nil {
+		return 0, err
+	}
+	var version int
+	err = db.QueryRow("SELECT coalesce(max(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// DBMigrate creates the tables, and applies the migrations a database is missing in order
+func DBMigrate(db DBTX) error {
+	version, err := DBSchemaVersion(db)
+	if err != nil {
+		fmt.Println("error reading schema version:", err)
+		return err
+	}
+	if version > SchemaVersion() {
+		return fmt.Errorf("schema version %d of the database is newer than version %d of the bot", version, SchemaVersion())
+	}
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		// a failed migration leaves the database at the previous version
+		err = DBTransaction(db, func(tx DBTX) error {
+			err := m.Up(tx)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("INSERT INTO schema_version (version, description) VALUES (?, ?)", m.Version, m.Description)
+			return err
+		})
+		if err != nil {
+			fmt.Printf("error migrating to version %d (%s): %s\n", m.Version, m.Description, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// dbAddColumn adds a column to a table that was created by an earlier version
+func dbAddColumn(db DBTX, table, column, definition string) error {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// migrateBaseline creates the tables of the first version, which ran a single tournament
+func migrateBaseline(db DBTX) error {
+	for _, statement := range []string{
+		"CREATE TABLE IF NOT EXISTS options (id INTEGER PRIMARY KEY, key TEXT NOT NULL, value TEXT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS participants (discord_id TEXT PRIMARY KEY NOT NULL, ign TEXT UNIQUE NOT NULL, group_id INTEGER DEFAULT 0)",
+		"CREATE TABLE IF NOT EXISTS groups (id INTEGER PRIMARY KEY, name TEXT NOT NULL, complete INTEGER DEFAULT 0)",
+		"CREATE TABLE IF NOT EXISTS matches (id INTEGER PRIMARY KEY, group_id INTEGER NOT NULL, bestof INTEGER NOT NULL, player1 TEXT NOT NULL, player2 TEXT NOT NULL, score1 INTEGER DEFAULT 0, score2 INTEGER DEFAULT 0)",
+	} {
+		_, err := db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRatings creates the global ratings of all players
+func migrateRatings(db DBTX) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS ratings (ign TEXT PRIMARY KEY NOT NULL, rating REAL NOT NULL, games INTEGER DEFAULT 0)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS rating_history (id INTEGER PRIMARY KEY, ign TEXT NOT NULL, opponent TEXT NOT NULL, tournament TEXT NOT NULL, match_id INTEGER, rating REAL NOT NULL, change REAL NOT NULL, created DATETIME DEFAULT CURRENT_TIMESTAMP)")
+	return err
+}
+
+// migrateTournaments keeps every tournament under its own id, the tournament of an earlier version becomes the first one
+func migrateTournaments(db DBTX) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS tournaments (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished DATETIME,
+		winner TEXT
+	)`)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"options", "groups"} {
+		err = dbAddColumn(db, table, "tournament_id", "INTEGER DEFAULT 0")
+		if err != nil {
+			return err
+		}
+	}
+	// players register once per tournament, the primary key of earlier versions allowed only one tournament
+	var keyed int
+	err = db.QueryRow("SELECT count(*) FROM pragma_table_info('participants') WHERE name = 'tournament_id'").Scan(&keyed)
+	if err != nil {
+		return err
+	}
+	if keyed == 0 {
+		err = dbUpgradeParticipants(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	var legacy int
+	err = db.QueryRow("SELECT count(*) FROM options WHERE tournament_id = 0").Scan(&legacy)
+	if err != nil || legacy == 0 {
+		return err
+	}
+	res, err := db.Exec("INSERT INTO tournaments (name, winner) VALUES (coalesce((SELECT value FROM options WHERE key = 'name' AND tournament_id = 0), ''), (SELECT value FROM options WHERE key = 'winner' AND tournament_id = 0))")
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"options", "participants", "groups"} {
+		_, err = db.Exec(fmt.Sprintf("UPDATE %s SET tournament_id = ? WHERE tournament_id = 0", table), id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbUpgradeParticipants copies the participants of an earlier version into a table keyed by tournament
+func dbUpgradeParticipants(db DBTX) error {
+	for _, statement := range []string{
+		`CREATE TABLE tournament_participants (
+			tournament_id INTEGER DEFAULT 0,
+			discord_id TEXT NOT NULL,
+			ign TEXT NOT NULL,
+			group_id INTEGER DEFAULT 0,
+			seed INTEGER DEFAULT 0,
+			PRIMARY KEY (tournament_id, discord_id),
+			UNIQUE (tournament_id, ign)
+		)`,
+		"INSERT INTO tournament_participants (discord_id, ign, group_id, seed) SELECT discord_id, ign, group_id, seed FROM participants",
+		"DROP TABLE participants",
+		"ALTER TABLE tournament_participants RENAME TO participants",
+	} {
+		_, err := db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateGuilds keeps tournaments, ratings and settings per guild. Tournaments and ratings of an earlier
+// version belong to no guild until DBAdoptGuild.
+func migrateGuilds(db DBTX) error {
+	err := dbAddColumn(db, "tournaments", "guild_id", "TEXT DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = dbAddColumn(db, "rating_history", "guild_id", "TEXT DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS guilds (guild_id TEXT PRIMARY KEY NOT NULL, lang TEXT DEFAULT '', participant_role_id TEXT DEFAULT '')")
+	if err != nil {
+		return err
+	}
+	// the guild becomes part of the primary key of the ratings
+	var count int
+	err = db.QueryRow("SELECT count(*) FROM pragma_table_info('ratings') WHERE name = 'guild_id'").Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	for _, statement := range []string{
+		"CREATE TABLE guild_ratings (guild_id TEXT DEFAULT '', ign TEXT NOT NULL, rating REAL NOT NULL, games INTEGER DEFAULT 0, PRIMARY KEY (guild_id, ign))",
+		"INSERT INTO guild_ratings (ign, rating, games) SELECT ign, rating, games FROM ratings",
+		"DROP TABLE ratings",
+		"ALTER TABLE guild_ratings RENAME TO ratings",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRatingMembers keys the ratings by the discord id of the member instead of the name. A name belongs to the member
+// who registered with it last in the guild, the rating of a renamed member is the one with the most games. Names without
+// a known member keep their rating until a member registers with them, see dbClaimRating.
+func migrateRatingMembers(db DBTX) error {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM pragma_table_info('ratings') WHERE name = 'discord_id'").Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	member := `coalesce((SELECT p.discord_id FROM participants p JOIN tournaments t ON p.tournament_id = t.id
+		WHERE t.guild_id = %[1]s.guild_id AND p.ign = %[1]s.ign ORDER BY p.tournament_id DESC LIMIT 1), '` + legacyRatingPrefix + `' || %[1]s.ign)`
+	for _, statement := range []string{
+		"CREATE TABLE member_ratings (guild_id TEXT DEFAULT '', discord_id TEXT NOT NULL, ign TEXT NOT NULL, rating REAL NOT NULL, games INTEGER DEFAULT 0, PRIMARY KEY (guild_id, discord_id))",
+		"INSERT OR IGNORE INTO member_ratings (guild_id, discord_id, ign, rating, games) SELECT guild_id, " + fmt.Sprintf(member, "ratings") + ", ign, rating, games FROM ratings ORDER BY games DESC",
+		"DROP TABLE ratings",
+		"ALTER TABLE member_ratings RENAME TO ratings",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	err = dbAddColumn(db, "rating_history", "discord_id", "TEXT DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE rating_history SET discord_id = " + fmt.Sprintf(member, "rating_history"))
+	return err
+}
+
+// migrateSlots lets matches reference participants by id instead of by name, and groups, participants and matches
+// reference each other with foreign keys. Slots waiting for a player of an earlier version are parsed from their names.
+func migrateSlots(db DBTX) error {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM pragma_table_info('matches') WHERE name = 'player1_id'").Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	// the tables are rebuilt in the order of their references
+	for _, statement := range []string{
+		`CREATE TABLE tournament_groups (
+			id INTEGER PRIMARY KEY,
+			tournament_id INTEGER NOT NULL REFERENCES tournaments(id),
+			name TEXT NOT NULL,
+			complete INTEGER DEFAULT 0
+		)`,
+		"INSERT INTO tournament_groups (id, tournament_id, name, complete) SELECT id, tournament_id, name, complete FROM groups WHERE tournament_id IN (SELECT id FROM tournaments)",
+		"DROP TABLE groups",
+		"ALTER TABLE tournament_groups RENAME TO groups",
+		`CREATE TABLE tournament_participants (
+			id INTEGER PRIMARY KEY,
+			tournament_id INTEGER NOT NULL REFERENCES tournaments(id),
+			discord_id TEXT NOT NULL,
+			ign TEXT NOT NULL,
+			group_id INTEGER REFERENCES groups(id),
+			seed INTEGER DEFAULT 0,
+			UNIQUE (tournament_id, discord_id),
+			UNIQUE (tournament_id, ign)
+		)`,
+		`INSERT INTO tournament_participants (tournament_id, discord_id, ign, group_id, seed)
+			SELECT tournament_id, discord_id, ign, (SELECT id FROM groups WHERE id = group_id), seed FROM participants WHERE tournament_id IN (SELECT id FROM tournaments)`,
+		"DROP TABLE participants",
+		"ALTER TABLE tournament_participants RENAME TO participants",
+		`CREATE TABLE slot_matches (
+			id INTEGER PRIMARY KEY,
+			group_id INTEGER NOT NULL REFERENCES groups(id),
+			bestof INTEGER NOT NULL,
+			player1_id INTEGER REFERENCES participants(id),
+			source1_group INTEGER REFERENCES groups(id),
+			source1_rank INTEGER DEFAULT 0,
+			wildcard1 INTEGER DEFAULT 0,
+			player2_id INTEGER REFERENCES participants(id),
+			source2_group INTEGER REFERENCES groups(id),
+			source2_rank INTEGER DEFAULT 0,
+			wildcard2 INTEGER DEFAULT 0,
+			score1 INTEGER DEFAULT 0,
+			score2 INTEGER DEFAULT 0,
+			tiebreak INTEGER DEFAULT 0
+		)`,
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	type legacyMatch struct {
+		id, groupId, tournamentId, bestof int
+		player1, player2                  string
+		score1, score2, tiebreak          int
+	}
+	rows, err := db.Query("SELECT m.id, m.group_id, g.tournament_id, m.bestof, m.player1, m.player2, m.score1, m.score2, m.tiebreak FROM matches m JOIN groups g ON m.group_id = g.id")
+	if err != nil {
+		return err
+	}
+	var matches []legacyMatch
+	for rows.Next() {
+		var m legacyMatch
+		err = rows.Scan(&m.id, &m.groupId, &m.tournamentId, &m.bestof, &m.player1, &m.player2, &m.score1, &m.score2, &m.tiebreak)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		matches = append(matches, m)
+	}
+	rows.Close()
+	for _, m := range matches {
+		args := []any{m.id, m.groupId, m.bestof}
+		for _, name := range []string{m.player1, m.player2} {
+			ign, source, rank, wildcard := legacySlot(name)
+			var sourceGroup sql.NullInt64
+			if source > 0 {
+				sourceGroup = sql.NullInt64{Int64: int64(source), Valid: true}
+			}
+			args = append(args, dbParticipantId(db, m.tournamentId, ign), sourceGroup, rank, wildcard)
+		}
+		_, err = db.Exec(`INSERT INTO slot_matches (id, group_id, bestof, player1_id, source1_group, source1_rank, wildcard1, player2_id, source2_group, source2_rank, wildcard2, score1, score2, tiebreak)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, append(args, m.score1, m.score2, m.tiebreak)...)
+		if err != nil {
+			return err
+		}
+	}
+	for _, statement := range []string{
+		"DROP TABLE matches",
+		"ALTER TABLE slot_matches RENAME TO matches",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacySlot parses the name of a player in a match of an earlier version: "!G<group>[.<rank>]" waits for
+// the player reaching a rank in a group, "!W<n>" for a wildcard, and a bye has neither player nor source.
+func legacySlot(name string) (ign string, source, rank, wildcard int) {
+	switch {
+	case name == Bye:
+	case strings.HasPrefix(name, "!W"):
+		wildcard, _ = strconv.Atoi(name[2:])
+	case strings.HasPrefix(name, "!G"):
+		group, r, found := strings.Cut(name[2:], ".")
+		source, _ = strconv.Atoi(group)
+		rank = 1
+		if found {
+			rank, _ = strconv.Atoi(r)
+		}
+	default:
+		ign = name
+	}
+	return
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// InitialRating is the Elo rating of a player without games
+const InitialRating = 1500.0
+
+// RatingK is the maximum change of a rating by a single match
+const RatingK = 32.0
+
+// Rating is the Elo rating of a member, kept across tournaments and names
+type Rating struct {
+	DiscordId string
+	Player    string // the latest name of the member
+	Rating    float64
+	Games     int
+}
+
+// legacyRatingPrefix marks the ratings of names whose member is unknown, the next member registering with the name claims it
+const legacyRatingPrefix = "!"
+
+// RatingChange is one rated match of a player
+type RatingChange struct {
+	Opponent   string
+	Tournament string
+	Rating     float64 // after the match
+	Change     float64
+}
+
+// EloChange is the change of the rating of a player with rating a against a player with rating b,
+// result is 1 for a win, 0.5 for a draw and 0 for a loss
+func EloChange(a, b, result float64) float64 {
+	expected := 1 / (1 + math.Pow(10, (b-a)/400))
+	return RatingK * (result - expected)
+}
+
+// DBGetRating returns the rating of a member in a guild, members without games have the initial rating
+func DBGetRating(db DBTX, guildId, discordId string) Rating {
+	r := Rating{DiscordId: discordId, Rating: InitialRating}
+	db.QueryRow("SELECT ign, rating, games FROM ratings WHERE guild_id = ? AND discord_id = ?", guildId, discordId).Scan(&r.Player, &r.Rating, &r.Games)
+	return r
+}
+
+// dbClaimRating gives the rating of a name whose member is unknown to the member registering with it, unless the member has a rating already
+func dbClaimRating(db DBTX, guildId, discordId, ign string) error {
+	if DBGetRating(db, guildId, discordId).Games > 0 {
+		return nil
+	}
+	for _, table := range []string{"ratings", "rating_history"} {
+		_, err := db.Exec(fmt.Sprintf("UPDATE %s SET discord_id = ? WHERE guild_id = ? AND discord_id = ?", table), discordId, guildId, legacyRatingPrefix+ign)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DBRateMatch updates the ratings of both players of a match. A match which was rated before
+// is reverted first, so that a corrected result replaces the earlier one.
+func DBRateMatch(db DBTX, matchId int) error {
+	m, err := scanMatch(db.QueryRow(selectMatches+"WHERE m.id = ?", matchId))
+	if err != nil {
+		return err
+	}
+	tournamentId := DBGroupTournament(db, m.GroupId)
+	guildId := DBGetTournamentGuild(db, tournamentId)
+
+	rows, err := db.Query("SELECT discord_id, change FROM rating_history WHERE match_id = ?", matchId)
+	if err != nil {
+		return err
+	}
+	reverted := make(map[string]float64)
+	for rows.Next() {
+		var discordId string
+		var change float64
+		err = rows.Scan(&discordId, &change)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		reverted[discordId] += change
+	}
+	rows.Close()
+	for discordId, change := range reverted {
+		_, err = db.Exec("UPDATE ratings SET rating = rating - ?, games = games - 1 WHERE guild_id = ? AND discord_id = ?", change, guildId, discordId)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec("DELETE FROM rating_history WHERE match_id = ?", matchId)
+	if err != nil {
+		return err
+	}
+
+	// byes, placeholders and matches without result are not rated
+	if m.Player1[0] == '!' || m.Player2[0] == '!' || (m.Score1 == 0 && m.Score2 == 0) {
+		return nil
+	}
+	result := 0.5
+	if m.Score1 > m.Score2 {
+		result = 1
+	} else if m.Score2 > m.Score1 {
+		result = 0
+	}
+	r1, r2 := DBGetRating(db, guildId, DBGetDiscordId(db, tournamentId, m.Player1)), DBGetRating(db, guildId, DBGetDiscordId(db, tournamentId, m.Player2))
+	// the ratings show the names of this match
+	r1.Player, r2.Player = m.Player1, m.Player2
+	tournament := DBGetTournamentName(db, tournamentId)
+	for _, side := range []struct {
+		player, opponent Rating
+		result           float64
+	}{{r1, r2, result}, {r2, r1, 1 - result}} {
+		change := EloChange(side.player.Rating, side.opponent.Rating, side.result)
+		rating := side.player.Rating + change
+		_, err = db.Exec(`INSERT INTO ratings (guild_id, discord_id, ign, rating, games) VALUES (?, ?, ?, ?, 1)
+			ON CONFLICT(guild_id, discord_id) DO UPDATE SET ign = excluded.ign, rating = excluded.rating, games = games + 1`,
+			guildId, side.player.DiscordId, side.player.Player, rating)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("INSERT INTO rating_history (guild_id, discord_id, ign, opponent, tournament, match_id, rating, change) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			guildId, side.player.DiscordId, side.player.Player, side.opponent.Player, tournament, matchId, rating, change)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DBGetRatings returns the leaderboard of a guild, best first
+func DBGetRatings(db DBTX, guildId string) ([]Rating, error) {
+	rows, err := db.Query("SELECT discord_id, ign, rating, games FROM ratings WHERE guild_id = ? AND games > 0 ORDER BY rating DESC, ign", guildId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ratings []Rating
+	for rows.Next() {
+		var r Rating
+		err = rows.Scan(&r.DiscordId, &r.Player, &r.Rating, &r.Games)
+		if err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, r)
+	}
+	return ratings, nil
+}
+
+// DBGetRatingHistory returns the rated matches of a member, latest first
+func DBGetRatingHistory(db DBTX, guildId, discordId string, limit int) ([]RatingChange, error) {
+	rows, err := db.Query("SELECT opponent, tournament, rating, change FROM rating_history WHERE guild_id = ? AND discord_id = ? ORDER BY id DESC LIMIT ?", guildId, discordId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []RatingChange
+	for rows.Next() {
+		var c RatingChange
+		err = rows.Scan(&c.Opponent, &c.Tournament, &c.Rating, &c.Change)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, nil
+}
+
+// DBRatingSeeds seeds the registered players who have a rating in the order of their rating, unrated players stay unseeded
+func DBRatingSeeds(db DBTX, tournamentId int) map[string]int {
+	var rated []Rating
+	guildId := DBGetTournamentGuild(db, tournamentId)
+	for _, p := range DBGetParticipants(db, tournamentId, 0) {
+		if r := DBGetRating(db, guildId, DBGetDiscordId(db, tournamentId, p)); r.Games > 0 {
+			// seeded by the name in this tournament
+			r.Player = p
+			rated = append(rated, r)
+		}
+	}
+	sort.SliceStable(rated, func(i, j int) bool {
+		if rated[i].Rating != rated[j].Rating {
+			return rated[i].Rating > rated[j].Rating
+		}
+		return rated[i].Player < rated[j].Player
+	})
+	seeds := make(map[string]int)
+	for k, r := range rated {
+		seeds[r.Player] = k + 1
+	}
+	return seeds
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+)
+
+// Report is a result entered by a player, which counts once the opponent confirms it
+type Report struct {
+	Id         int
+	MatchId    int
+	GroupId    int
+	GroupName  string
+	ReporterId string // discord id of the member who entered the result
+	Player1    string
+	Player2    string
+	Score1     int
+	Score2     int
+	Status     string // pending, confirmed, disputed, or replaced by a later report of the match
+}
+
+// DBReportResult stores the result of the match between two players in an open group until the opponent confirms it.
+// Only the players of the match may report its result, a new report of a match replaces the pending one.
+func DBReportResult(db DBTX, tournamentId int, reporterId, p1, p2 string, score1, score2 int64) (Report, error) {
+	defer LockTournament(tournamentId)()
+	var report Report
+	err := DBTransaction(db, func(tx DBTX) error {
+		m, err := DBFindMatch(tx, tournamentId, p1, p2)
+		if err != nil {
+			return err
+		}
+		if reporterId != DBGetDiscordId(tx, tournamentId, m.Player1) && reporterId != DBGetDiscordId(tx, tournamentId, m.Player2) {
+			return errors.New(i18n[DBTournamentLang(tx, tournamentId)]["err-not-player"])
+		}
+		if m.Player1 != p1 {
+			score1, score2 = score2, score1
+		}
+		_, err = tx.Exec("UPDATE reports SET status = 'replaced' WHERE match_id = ? AND status = 'pending'", m.Id)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("INSERT INTO reports (match_id, reporter_id, score1, score2) VALUES (?, ?, ?, ?)", m.Id, reporterId, score1, score2)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		report, err = DBGetReport(tx, int(id))
+		return err
+	})
+	return report, err
+}
+
+// DBGetReport returns a report with the players of its match
+func DBGetReport(db DBTX, reportId int) (Report, error) {
+	var r Report
+	err := db.QueryRow(`SELECT r.id, r.match_id, g.id, g.name, r.reporter_id, p1.ign, p2.ign, r.score1, r.score2, r.status
+		FROM reports r JOIN matches m ON r.match_id = m.id JOIN groups g ON m.group_id = g.id
+		JOIN participants p1 ON m.player1_id = p1.id JOIN participants p2 ON m.player2_id = p2.id WHERE r.id = ?`, reportId).Scan(
+		&r.Id, &r.MatchId, &r.GroupId, &r.GroupName, &r.ReporterId, &r.Player1, &r.Player2, &r.Score1, &r.Score2, &r.Status)
+	return r, err
+}
+
+// DBReportOpponents are the discord ids of the players who may confirm or dispute a report, all players of the match except for the reporter
+func DBReportOpponents(db DBTX, report Report) []string {
+	tournamentId := DBGroupTournament(db, report.GroupId)
+	var opponents []string
+	for _, player := range []string{report.Player1, report.Player2} {
+		if id := DBGetDiscordId(db, tournamentId, player); id != "" && id != report.ReporterId {
+			opponents = append(opponents, id)
+		}
+	}
+	return opponents
+}
+
+// DBConfirmReport sets the score of the match of a pending report, and returns the confirmed report
+func DBConfirmReport(db DBTX, reportId int) (Report, error) {
+	return dbDecideReport(db, reportId, "confirmed")
+}
+
+// DBDisputeReport marks a pending report as disputed, the score of its match does not change
+func DBDisputeReport(db DBTX, reportId int) (Report, error) {
+	return dbDecideReport(db, reportId, "disputed")
+}
+
+func dbDecideReport(db DBTX, reportId int, status string) (Report, error) {
+	report, err := DBGetReport(db, reportId)
+	if err != nil {
+		return report, err
+	}
+	defer LockTournament(DBGroupTournament(db, report.GroupId))()
+	err = DBTransaction(db, func(tx DBTX) error {
+		// the report may have been decided while waiting for the lock
+		report, err = DBGetReport(tx, reportId)
+		if err != nil {
+			return err
+		}
+		var complete bool
+		err = tx.QueryRow("SELECT complete FROM groups WHERE id = ?", report.GroupId).Scan(&complete)
+		if err != nil {
+			return err
+		}
+		if report.Status != "pending" || complete {
+			return errors.New(i18n[DBTournamentLang(tx, DBGroupTournament(tx, report.GroupId))]["err-report"])
+		}
+		if status == "confirmed" {
+			err = DBSetScore(tx, report.MatchId, int64(report.Score1), int64(report.Score2))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("UPDATE reports SET status = ? WHERE id = ?", status, reportId)
+		report.Status = status
+		return err
+	})
+	return report, err
+}
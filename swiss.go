@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Bye is the opponent of a player who sits out a round, bye matches are won without playing
+const Bye = "!BYE"
+
+// SwissFormat pairs all players against opponents with similar standings for a fixed number of rounds.
+// Each round is stored as a group, the next round is paired once all matches of the current round have a result.
+type SwissFormat struct{}
+
+// SwissRounds is the configured number of rounds, or enough rounds to find a single unbeaten player
+func SwissRounds(settings Settings, players int) int {
+	if settings.Rounds > 0 {
+		return int(settings.Rounds)
+	}
+	rounds := 0
+	for 1<<rounds < players {
+		rounds++
+	}
+	return rounds
+}
+
+func (SwissFormat) Plan(participants []string, settings Settings) (Plan, error) {
+	lang := settings.Lang
+	if len(participants) < 2 {
+		return Plan{}, fmt.Errorf(i18n[lang]["err-too-few"], 2)
+	}
+	if SwissRounds(settings, len(participants)) >= len(participants) {
+		return Plan{}, fmt.Errorf(i18n[lang]["err-rounds"], len(participants)-1)
+	}
+	// nobody has played yet, pair randomly
+	order := DrawOrder(participants, nil, settings.Rand())
+	return SwissRound(1, order, nil, settings.BestOf, lang), nil
+}
+
+// SwissRound pairs the players of a round in the order of their standing, avoiding rematches if possible
+func SwissRound(round int, order []string, played map[string]map[string]bool, bestof int64, lang string) Plan {
+	var matches []PlannedMatch
+	players := order
+	if len(players)%2 == 1 {
+		// the lowest ranked player who did not have a bye yet sits out
+		bye := len(players) - 1
+		for k := len(players) - 1; k >= 0; k-- {
+			if !played[players[k]][Bye] {
+				bye = k
+				break
+			}
+		}
+		matches = append(matches, PlannedMatch{bestof, Slot{Player: players[bye]}, Slot{Player: Bye}})
+		players = append(append([]string{}, players[:bye]...), players[bye+1:]...)
+	}
+	pairs, ok := swissPairs(players, played)
+	if !ok {
+		// too many rounds to avoid rematches, or no pairing found within the search limit: pair in order of the standings
+		pairs = nil
+		for k := 0; k+1 < len(players); k += 2 {
+			pairs = append(pairs, [2]string{players[k], players[k+1]})
+		}
+	}
+	for _, pair := range pairs {
+		matches = append(matches, PlannedMatch{bestof, Slot{Player: pair[0]}, Slot{Player: pair[1]}})
+	}
+	plan := Plan{Lang: lang}
+	plan.AddGroup(fmt.Sprintf(i18n[lang]["swiss-round"], round), order, matches...)
+	return plan
+}
+
+// swissSearchLimit bounds the steps of the search for a pairing without rematches, as it runs while the tournament is locked
+const swissSearchLimit = 100000
+
+// pair the best player with the best opponent not played yet, backtracking if the rest cannot be paired.
+// The search gives up after swissSearchLimit steps.
+func swissPairs(players []string, played map[string]map[string]bool) ([][2]string, bool) {
+	steps := swissSearchLimit
+	return swissSearch(players, played, &steps)
+}
+
+func swissSearch(players []string, played map[string]map[string]bool, steps *int) ([][2]string, bool) {
+	if len(players) == 0 {
+		return nil, true
+	}
+	if *steps <= 0 {
+		return nil, false
+	}
+	*steps--
+	p := players[0]
+	for k := 1; k < len(players); k++ {
+		q := players[k]
+		if played[p][q] {
+			continue
+		}
+		rest := append(append([]string{}, players[1:k]...), players[k+1:]...)
+		if pairs, ok := swissSearch(rest, played, steps); ok {
+			return append([][2]string{{p, q}}, pairs...), true
+		}
+	}
+	return nil, false
+}
+
+// SwissScores calculates the standings over all rounds, ordered by wins, Buchholz and score difference.
+// Tiebreak matches after the last round do not count.
+func SwissScores(db DBTX, tournamentId int) ([]string, map[string]Score, map[string]map[string]bool) {
+	var matches []Match
+	for _, m := range DBGetMatches(db, tournamentId, 0) {
+		if m.Tiebreak == 0 {
+			matches = append(matches, m)
+		}
+	}
+	scores := CalcScores(matches)
+	played := make(map[string]map[string]bool)
+	for _, m := range matches {
+		for _, pair := range [][2]string{{m.Player1, m.Player2}, {m.Player2, m.Player1}} {
+			if played[pair[0]] == nil {
+				played[pair[0]] = make(map[string]bool)
+			}
+			played[pair[0]][pair[1]] = true
+		}
+	}
+	// Buchholz is the sum of the wins of all opponents
+	var order []string
+	for p, s := range scores {
+		for opponent := range played[p] {
+			s.Buchholz += scores[opponent].Wins
+		}
+		scores[p] = s
+		order = append(order, p)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := scores[order[i]], scores[order[j]]
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		if a.Diff != b.Diff {
+			return a.Diff > b.Diff
+		}
+		return order[i] < order[j]
+	})
+	return order, scores, played
+}
+
+// swissWinBy identifies the criterion which ranks a above b
+func swissWinBy(a, b Score) (WinBy, int) {
+	if a.Wins != b.Wins {
+		return WinByWins, a.Wins
+	}
+	if a.Buchholz != b.Buchholz {
+		return WinByBuchholz, a.Buchholz
+	}
+	if a.Diff != b.Diff {
+		return WinByDiff, a.Diff
+	}
+	return 0, a.Diff
+}
+
+// SwissStanding ranks the players in the order of the swiss table
+func SwissStanding(order []string, scores map[string]Score) Standing {
+	result := Standing{Ranking: order}
+	for i, p := range order {
+		winBy, score := WinByWins, scores[p].Wins
+		if i+1 < len(order) {
+			winBy, score = swissWinBy(scores[p], scores[order[i+1]])
+		}
+		result.WinBy = append(result.WinBy, winBy)
+		result.Scores = append(result.Scores, score)
+	}
+	return result
+}
+
+// Winner ranks the players of the swiss table. Players tied for the first place are ranked by the tiebreak matches
+// among them in the group of the last round, until these break the tie.
+func (SwissFormat) Winner(db DBTX, groupId int) (Standing, error) {
+	tournamentId := DBGroupTournament(db, groupId)
+	order, scores, _ := SwissScores(db, tournamentId)
+	result := SwissStanding(order, scores)
+	tied := 1
+	for tied < len(order) && result.WinBy[tied-1] == 0 {
+		tied++
+	}
+	if tied == 1 {
+		return result, nil
+	}
+	leaders := RankPlayers(order[:tied], []string{TiebreakMatches}, scores, DBGetMatches(db, tournamentId, groupId), groupId)
+	if leaders.WinBy[0] == 0 {
+		return result, &DrawError{Place: 1, Players: order[:tied], Lang: DBTournamentLang(db, tournamentId)}
+	}
+	copy(result.Ranking, leaders.Ranking)
+	copy(result.WinBy, leaders.WinBy[:tied-1])
+	copy(result.Scores, leaders.Scores[:tied-1])
+	return result, nil
+}
+
+func (f SwissFormat) Complete(db DBTX, groupId int) ([]Advance, *Standing, error) {
+	tournamentId := DBGroupTournament(db, groupId)
+	var round int
+	err := db.QueryRow("SELECT count(*) FROM groups WHERE tournament_id = ?", tournamentId).Scan(&round)
+	if err != nil {
+		return nil, nil, err
+	}
+	settings := DBGetSettings(db, tournamentId)
+	players := DBGetParticipants(db, tournamentId, 0)
+
+	if round >= SwissRounds(settings, len(players)) {
+		// the last round has been played, the leader wins the tournament
+		standing, err := f.Winner(db, groupId)
+		var draw *DrawError
+		if errors.As(err, &draw) {
+			// the leaders play it out, the round stays open
+			return nil, nil, DBScheduleTiebreak(db, groupId, draw)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		err = checkRanking(db, groupId, standing)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = db.Exec("UPDATE groups SET complete = 1 WHERE id = ?", groupId)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []Advance{{Player: standing.Ranking[0], Group: Group{Id: 0, Name: i18n[settings.Lang]["tournament-winner"]}}}, &standing, nil
+	}
+
+	_, err = db.Exec("UPDATE groups SET complete = 1 WHERE id = ?", groupId)
+	if err != nil {
+		return nil, nil, err
+	}
+	order, _, played := SwissScores(db, tournamentId)
+	err = DBWritePlan(db, tournamentId, SwissRound(round+1, order, played, settings.BestOf, settings.Lang))
+	if err != nil {
+		return nil, nil, err
+	}
+	// nobody advances individually, everybody plays the next round
+	return []Advance{}, nil, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// tiebreakers are the criteria to rank the players of a group, applied in the order chosen for the tournament
+var tiebreakers = map[string]WinBy{
+	"wins":          WinByWins,
+	"points":        WinByPoints,
+	"diff":          WinByDiff,
+	"h2h":           WinByHeadToHead,
+	"mini":          WinByMiniTable,
+	"draw":          WinByDraw,
+	TiebreakMatches: WinByTiebreak,
+}
+
+// TiebreakMatches ranks players by the tiebreak matches played among them, it always comes last and cannot be chosen
+const TiebreakMatches = "tiebreak"
+
+// DefaultTiebreakers rank by wins, then by score difference
+var DefaultTiebreakers = []string{"wins", "diff"}
+
+// ParseTiebreakers reads a comma separated chain of tiebreakers, an empty chain is the default. Errors are in the given language.
+func ParseTiebreakers(chain, lang string) ([]string, error) {
+	if strings.TrimSpace(chain) == "" {
+		return DefaultTiebreakers, nil
+	}
+	var result []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(chain, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := tiebreakers[name]; !ok || seen[name] || name == TiebreakMatches {
+			return nil, fmt.Errorf(i18n[lang]["err-tiebreakers"], name)
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+	return result, nil
+}
+
+// tiebreakValue rates a player by one criterion, higher is better. Head-to-head and the mini table only
+// count the matches among the players still tied, the draw is random but stays the same for a group.
+func tiebreakValue(criterion, player string, tied []string, scores map[string]Score, matches []Match, groupId int) []int {
+	switch criterion {
+	case "wins":
+		return []int{scores[player].Wins}
+	case "points":
+		return []int{scores[player].Points}
+	case "diff":
+		return []int{scores[player].Diff}
+	case "h2h", "mini", TiebreakMatches:
+		among := make(map[string]bool)
+		for _, p := range tied {
+			among[p] = true
+		}
+		// the regular matches among the tied players, or only their tiebreak matches
+		var direct []Match
+		for _, m := range matches {
+			if among[m.Player1] && among[m.Player2] && (m.Tiebreak > 0) == (criterion == TiebreakMatches) {
+				direct = append(direct, m)
+			}
+		}
+		mini := CalcScores(direct)[player]
+		switch criterion {
+		case "h2h":
+			return []int{mini.Wins}
+		case TiebreakMatches:
+			return []int{mini.Wins, mini.Diff}
+		}
+		return []int{mini.Wins, mini.Diff, mini.Points}
+	case "draw":
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%d:%s", groupId, player)
+		return []int{int(h.Sum32())}
+	}
+	return []int{0}
+}
+
+func compareValues(a, b []int) int {
+	for k := range a {
+		if a[k] != b[k] {
+			if a[k] > b[k] {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+// RankPlayers orders the players by the chain of tiebreakers, each criterion only applies to the players still tied by the previous ones
+func RankPlayers(players []string, chain []string, scores map[string]Score, matches []Match, groupId int) Standing {
+	result := Standing{}
+	players = append([]string{}, players...)
+	sort.Strings(players)
+	if len(chain) == 0 {
+		// nothing left to break the tie
+		result.Ranking = players
+		result.WinBy = make([]WinBy, len(players))
+		result.Scores = make([]int, len(players))
+		return result
+	}
+
+	criterion := chain[0]
+	values := make(map[string][]int)
+	for _, p := range players {
+		values[p] = tiebreakValue(criterion, p, players, scores, matches, groupId)
+	}
+	sort.SliceStable(players, func(i, j int) bool {
+		return compareValues(values[players[i]], values[players[j]]) > 0
+	})
+	for start := 0; start < len(players); {
+		end := start + 1
+		for end < len(players) && compareValues(values[players[start]], values[players[end]]) == 0 {
+			end++
+		}
+		tied := RankPlayers(players[start:end], chain[1:], scores, matches, groupId)
+		result.Ranking = append(result.Ranking, tied.Ranking...)
+		result.WinBy = append(result.WinBy, tied.WinBy...)
+		result.Scores = append(result.Scores, tied.Scores...)
+		// the last of the tied players is ahead of the next ones by this criterion
+		last := len(result.Ranking) - 1
+		result.WinBy[last] = tiebreakers[criterion]
+		result.Scores[last] = values[players[end-1]][0]
+		start = end
+	}
+	return result
+}